@@ -2,85 +2,464 @@ package main
 
 import (
 	"context"
-	"log"
+	"flag"
+	"io"
+	"log/slog"
 	"os"
+	"slices"
 	"strconv"
 	"time"
 
+	"github.com/digitalocean/godo"
+	"github.com/thomasvincent/github-runners-infra/internal/cleanup"
 	"github.com/thomasvincent/github-runners-infra/internal/digitalocean"
 	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/metrics"
+	"github.com/thomasvincent/github-runners-infra/internal/state"
+	"github.com/thomasvincent/github-runners-infra/internal/webhook"
 )
 
+// stateRetention is how long finished jobs and deleted droplets stay in the
+// state store before being pruned.
+const stateRetention = 7 * 24 * time.Hour
+
 func main() {
+	dryRun := flag.Bool("dry-run", false, "report what would be removed without removing anything")
+	output := flag.String("output", "", "print a report of removals as table or json (table if --dry-run)")
+	flag.Parse()
+
+	format := *output
+	if format == "" && *dryRun {
+		format = "table"
+	}
+	// Keep stdout for the report so it can be piped
+	logOut := os.Stdout
+	if format != "" {
+		logOut = os.Stderr
+	}
+	logger := newLogger(os.Getenv("LOG_LEVEL"), logOut)
+	slog.SetDefault(logger)
+
+	if format != "" && format != "table" && format != "json" {
+		fatal("Invalid --output, want table or json", "output", format)
+	}
+
+	var err error
 	doToken := os.Getenv("DIGITALOCEAN_TOKEN")
 	if doToken == "" {
-		log.Fatal("DIGITALOCEAN_TOKEN is required")
+		fatal("DIGITALOCEAN_TOKEN is required")
 	}
 
+	policy := cleanup.Default()
+	if path := os.Getenv("CLEANUP_POLICY_FILE"); path != "" {
+		policy, err = cleanup.Load(path)
+		if err != nil {
+			fatal("Failed to load cleanup policy", "error", err)
+		}
+	}
+
+	// Cleanup only lists and deletes droplets, so no cloud-init template
 	client, err := digitalocean.NewClient(digitalocean.Config{
-		Token:         doToken,
-		CloudInitPath: "cloud-init/runner.yaml.tmpl",
+		Token:  doToken,
+		Logger: logger,
 	})
 	if err != nil {
-		log.Fatalf("Failed to create DO client: %v", err)
+		fatal("Failed to create DO client", "error", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
-	maxAge := 60 * time.Minute
-	deleted, err := client.CleanupOldDroplets(ctx, maxAge)
+	// Results go to a node_exporter textfile so drift can be alerted on. A
+	// dry run removes nothing, so it leaves the last real run's results.
+	// LastSuccess only advances once the run has completed.
+	stats := metrics.NewCleanup()
+	succeeded := false
+	if path := os.Getenv("METRICS_TEXTFILE"); path != "" && !*dryRun {
+		defer func() {
+			if succeeded {
+				stats.LastSuccess.SetToCurrentTime()
+			} else {
+				stats.KeepLastSuccess(path)
+			}
+			if err := stats.WriteTextfile(path); err != nil {
+				slog.Error("Failed to write metrics", "path", path, "error", err)
+			}
+		}()
+	}
+
+	rep := &report{DryRun: *dryRun}
+	if format != "" {
+		defer func() {
+			if err := rep.write(os.Stdout, format); err != nil {
+				slog.Error("Failed to write report", "error", err)
+			}
+		}()
+	}
+
+	statePath := os.Getenv("STATE_PATH")
+	if statePath == "" {
+		statePath = "/var/lib/github-runners/state.json"
+	}
+	store, err := state.Open(statePath)
+	if err != nil {
+		slog.Warn("Failed to open state store, using droplet age only", "error", err)
+	}
+
+	droplets, err := client.ListRunnerDroplets(ctx)
 	if err != nil {
-		log.Fatalf("Cleanup failed: %v", err)
+		fatal("Failed to list runner droplets", "error", err)
+	}
+
+	// Droplets under debugging, and their runners, are left alone
+	excluded := make(map[int]bool)
+	excludedNames := make(map[string]bool)
+	var candidates []godo.Droplet
+	for _, d := range droplets {
+		if policy.Excluded(d.Tags) {
+			slog.Info("Skipping excluded droplet", "runner_name", d.Name, "droplet_id", d.ID, "tag", policy.ExcludeTag)
+			excluded[d.ID] = true
+			excludedNames[d.Name] = true
+			continue
+		}
+		candidates = append(candidates, d)
+	}
+
+	now := time.Now()
+	planned := make(map[int]bool)
+	var dropletRemovals []removal
+	if store != nil {
+		for _, r := range releasedDroplets(store, droplets, now, *dryRun) {
+			if excluded[int(r.ID)] {
+				continue
+			}
+			planned[int(r.ID)] = true
+			dropletRemovals = append(dropletRemovals, r)
+		}
+	}
+	for _, d := range digitalocean.StaleDroplets(candidates, policy.MaxAgeFor, now) {
+		if !planned[d.ID] {
+			planned[d.ID] = true
+			dropletRemovals = append(dropletRemovals, dropletRemoval(d, reasonAge, now))
+		}
+	}
+
+	deleted := make(map[string]int)
+	for i := range dropletRemovals {
+		r := &dropletRemovals[i]
+		if !*dryRun && deleteDroplet(ctx, client, store, r) {
+			deleted[r.Reason]++
+		}
+		rep.add(*r)
+	}
+	if !*dryRun {
+		slog.Info("Deleted runner droplets", "released", deleted[reasonReleased], "stale", deleted[reasonAge])
+		stats.DropletsDeleted.WithLabelValues("released").Set(float64(deleted[reasonReleased]))
+		stats.DropletsDeleted.WithLabelValues("stale").Set(float64(deleted[reasonAge]))
+	}
+
+	if store != nil && !*dryRun {
+		pruned := syncStore(store, droplets)
+		stats.StateRecordsPruned.Set(float64(pruned))
 	}
-	log.Printf("Cleanup: deleted %d stale runner droplets", deleted)
 
 	// Deregister offline ghost runners from GitHub (if credentials are available)
 	appIDStr := os.Getenv("APP_ID")
-	installIDStr := os.Getenv("APP_INSTALLATION_ID")
 	keyPath := os.Getenv("APP_PRIVATE_KEY_FILE")
-	if appIDStr == "" || installIDStr == "" || keyPath == "" {
-		log.Printf("GitHub App credentials not set, skipping runner deregistration")
+	if appIDStr == "" || keyPath == "" {
+		slog.Info("GitHub App credentials not set, skipping runner deregistration")
+		succeeded = true
 		return
 	}
 
 	appID, err := strconv.ParseInt(appIDStr, 10, 64)
 	if err != nil {
-		log.Printf("Invalid APP_ID, skipping runner deregistration: %v", err)
+		slog.Error("Invalid APP_ID, skipping runner deregistration", "error", err)
 		return
 	}
-	installID, err := strconv.ParseInt(installIDStr, 10, 64)
+	allowed, err := gh.ParseInstallationIDs(os.Getenv("APP_INSTALLATION_IDS"))
 	if err != nil {
-		log.Printf("Invalid APP_INSTALLATION_ID, skipping runner deregistration: %v", err)
+		slog.Error("Invalid APP_INSTALLATION_IDS, skipping runner deregistration", "error", err)
 		return
 	}
 	privateKey, err := os.ReadFile(keyPath)
 	if err != nil {
-		log.Printf("Failed to read private key, skipping runner deregistration: %v", err)
+		slog.Error("Failed to read private key, skipping runner deregistration", "error", err)
 		return
 	}
 
 	githubApp := &gh.App{
-		AppID:          appID,
-		InstallationID: installID,
-		PrivateKey:     privateKey,
+		AppID:         appID,
+		PrivateKey:    privateKey,
+		KeyPassphrase: []byte(os.Getenv("APP_PRIVATE_KEY_PASSPHRASE")),
+		APIURL:        os.Getenv("GITHUB_API_URL"),
+		WebURL:        os.Getenv("GITHUB_WEB_URL"),
+		Logger:        logger,
+	}
+	if prevPath := os.Getenv("APP_PREVIOUS_PRIVATE_KEY_FILE"); prevPath != "" {
+		githubApp.PreviousKey, err = os.ReadFile(prevPath)
+		if err != nil {
+			slog.Error("Failed to read previous private key, skipping runner deregistration", "error", err)
+			return
+		}
 	}
 
-	repos, err := githubApp.ListInstallationRepos()
+	// JIT runners are registered before their droplet boots, so they show as
+	// offline until then; keep any whose droplet is still alive.
+	live := liveRunnerNames(store, droplets, planned)
+	keep := func(r gh.Runner) bool { return live[r.Name] || excludedNames[r.Name] }
+
+	installations, err := githubApp.ListInstallations(ctx)
 	if err != nil {
-		log.Printf("Failed to list installation repos: %v", err)
+		slog.Error("Failed to list app installations", "error", err)
 		return
 	}
 
+	var offline []pendingRunner
+	for _, inst := range installations {
+		if len(allowed) > 0 && !slices.Contains(allowed, inst.ID) {
+			continue
+		}
+		offline = append(offline, offlineRunners(gh.WithInstallation(ctx, inst.ID), githubApp, inst, keep)...)
+	}
+
 	totalRemoved := 0
+	for _, p := range offlineLongEnough(store, offline, policy.RunnerOfflineMin.Std(), now, *dryRun) {
+		if !*dryRun && removeRunner(&p.removal, p.remove) {
+			totalRemoved++
+		}
+		rep.add(p.removal)
+	}
+
+	if !*dryRun {
+		slog.Info("Deregistered offline ghost runners from GitHub", "count", totalRemoved)
+		stats.GhostRunnersRemoved.Set(float64(totalRemoved))
+	}
+	succeeded = true
+}
+
+// pendingRunner is an offline runner and how to deregister it.
+type pendingRunner struct {
+	removal
+	remove func() error
+}
+
+// offlineRunners returns the offline runners of one app installation: those
+// of its repos and, for an org installation, the org's.
+func offlineRunners(ctx context.Context, githubApp *gh.App, inst gh.Installation, keep func(gh.Runner) bool) []pendingRunner {
+	logger := slog.With("installation_id", inst.ID)
+	repos, err := githubApp.ListInstallationRepos(ctx)
+	if err != nil {
+		logger.Error("Failed to list installation repos", "error", err)
+		return nil
+	}
+
+	var offline []pendingRunner
 	for _, repo := range repos {
-		removed, err := githubApp.RemoveOfflineRepoRunners(repo[0], repo[1])
+		scope := repo[0] + "/" + repo[1]
+		runners, err := githubApp.ListRepoRunners(ctx, repo[0], repo[1])
 		if err != nil {
-			log.Printf("Failed to clean runners for %s/%s: %v", repo[0], repo[1], err)
+			logger.Error("Failed to list runners", "repo", scope, "error", err)
 			continue
 		}
-		totalRemoved += removed
+		for _, r := range gh.OfflineRunners(runners, keep) {
+			offline = append(offline, pendingRunner{runnerRemoval(r, scope), func() error {
+				return githubApp.RemoveRepoRunner(ctx, repo[0], repo[1], r.ID)
+			}})
+		}
 	}
 
-	log.Printf("Cleanup: deregistered %d offline ghost runners from GitHub", totalRemoved)
+	// Org-scoped pools register runners with the installation's org.
+	if inst.Account.Type == "Organization" {
+		org := inst.Account.Login
+		runners, err := githubApp.ListOrgRunners(ctx, org)
+		if err != nil {
+			logger.Error("Failed to list org runners", "org", org, "error", err)
+		}
+		// Only touch runners this system provisioned; org runners may be shared.
+		for _, r := range gh.OfflineRunners(runners, func(r gh.Runner) bool {
+			return keep(r) || !webhook.EphemeralRunnerName(r.Name)
+		}) {
+			offline = append(offline, pendingRunner{runnerRemoval(r, org), func() error {
+				return githubApp.RemoveOrgRunner(ctx, org, r.ID)
+			}})
+		}
+	}
+	return offline
+}
+
+// offlineLongEnough returns the runners that have been seen offline for at
+// least minOffline. Sightings are kept in the state store across runs; a dry
+// run reads them without recording new ones.
+func offlineLongEnough(store *state.Store, runners []pendingRunner, minOffline time.Duration, now time.Time, dryRun bool) []pendingRunner {
+	if minOffline <= 0 {
+		return runners
+	}
+	if store == nil {
+		slog.Warn("No state store to track offline runners, skipping deregistration", "min_offline", minOffline.String())
+		return nil
+	}
+
+	keys := make([]string, len(runners))
+	for i, p := range runners {
+		keys[i] = p.Scope + "/" + p.Name
+	}
+	var since map[string]time.Time
+	var err error
+	if dryRun {
+		since, err = store.OfflineRunners()
+	} else {
+		since, err = store.RecordOfflineRunners(keys, now)
+	}
+	if err != nil {
+		slog.Error("Failed to track offline runners, skipping deregistration", "error", err)
+		return nil
+	}
+
+	var due []pendingRunner
+	for i, p := range runners {
+		first, ok := since[keys[i]]
+		if ok && now.Sub(first) >= minOffline {
+			due = append(due, p)
+		}
+	}
+	return due
+}
+
+// liveRunnerNames returns the runner names of droplets that exist, that the
+// state store does not know to be deleted and that are not about to be
+// removed.
+func liveRunnerNames(store *state.Store, droplets []godo.Droplet, removing map[int]bool) map[string]bool {
+	live := make(map[string]bool)
+	if store == nil {
+		return live
+	}
+	recorded, err := store.Droplets()
+	if err != nil {
+		slog.Error("Failed to read state store", "error", err)
+		return live
+	}
+	exists := make(map[int]bool, len(droplets))
+	for _, d := range droplets {
+		exists[d.ID] = true
+	}
+	for _, d := range recorded {
+		if d.Status != state.DropletDeleted && exists[d.ID] && !removing[d.ID] {
+			live[d.RunnerName] = true
+		}
+	}
+	return live
+}
+
+// releasedDroplets returns removals for droplets whose jobs have already
+// completed according to the store, regardless of droplet age. droplets
+// supplies creation time and price. Released droplets that are already gone
+// are only recorded deleted, unless this is a dry run.
+func releasedDroplets(store *state.Store, droplets []godo.Droplet, now time.Time, dryRun bool) []removal {
+	recorded, err := store.Droplets()
+	if err != nil {
+		slog.Error("Failed to read state store", "error", err)
+		return nil
+	}
+	byID := make(map[int]godo.Droplet, len(droplets))
+	for _, d := range droplets {
+		byID[d.ID] = d
+	}
+
+	var out []removal
+	for _, d := range recorded {
+		if d.Status != state.DropletReleased {
+			continue
+		}
+		live, ok := byID[d.ID]
+		if !ok {
+			if !dryRun {
+				if err := store.MarkDropletDeleted(d.ID); err != nil {
+					slog.Error("Failed to record droplet deleted", "runner_name", d.RunnerName, "droplet_id", d.ID, "error", err)
+				}
+			}
+			continue
+		}
+		out = append(out, dropletRemoval(live, reasonReleased, now))
+	}
+	return out
+}
+
+// deleteDroplet deletes the droplet of a removal and records it in the
+// store. Failures are logged and noted on r.
+func deleteDroplet(ctx context.Context, client *digitalocean.Client, store *state.Store, r *removal) bool {
+	logger := slog.With("runner_name", r.Name, "droplet_id", r.ID, "reason", r.Reason)
+	logger.Info("Deleting runner droplet", "created", r.Created)
+	if err := client.DeleteDroplet(ctx, int(r.ID)); err != nil {
+		logger.Error("Failed to delete droplet", "error", err)
+		r.Error = err.Error()
+		return false
+	}
+	if store != nil {
+		if err := store.MarkDropletDeleted(int(r.ID)); err != nil {
+			logger.Error("Failed to record droplet deleted", "error", err)
+		}
+	}
+	r.Removed = true
+	return true
+}
+
+// removeRunner deregisters the runner of a removal with remove. Failures are
+// logged and noted on r.
+func removeRunner(r *removal, remove func() error) bool {
+	logger := slog.With("runner_name", r.Name, "runner_id", r.ID, "scope", r.Scope)
+	logger.Info("Removing offline runner", "reason", r.Reason)
+	if err := remove(); err != nil {
+		logger.Error("Failed to remove runner", "error", err)
+		r.Error = err.Error()
+		return false
+	}
+	r.Removed = true
+	return true
+}
+
+// syncStore marks droplets that no longer exist as deleted and prunes old
+// history from the state store. Returns the number of records pruned.
+func syncStore(store *state.Store, live []godo.Droplet) int {
+	exists := make(map[int]bool, len(live))
+	for _, d := range live {
+		exists[d.ID] = true
+	}
+
+	recorded, err := store.Droplets()
+	if err != nil {
+		slog.Error("Failed to read state store", "error", err)
+		return 0
+	}
+	for _, d := range recorded {
+		if d.Status != state.DropletDeleted && !exists[d.ID] {
+			if err := store.MarkDropletDeleted(d.ID); err != nil {
+				slog.Error("Failed to record droplet deleted", "runner_name", d.RunnerName, "droplet_id", d.ID, "error", err)
+			}
+		}
+	}
+
+	pruned, err := store.Prune(stateRetention)
+	if err != nil {
+		slog.Error("Failed to prune state store", "error", err)
+		return 0
+	}
+	slog.Info("Pruned old state records", "count", pruned)
+	return pruned
+}
+
+// newLogger returns a JSON logger on w at the given level (debug, info, warn
+// or error; info if empty or unknown).
+func newLogger(level string, w io.Writer) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: lvl}))
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
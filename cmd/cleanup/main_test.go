@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/thomasvincent/github-runners-infra/internal/state"
+)
+
+func TestReleasedDroplets(t *testing.T) {
+	store := state.NewMemory()
+	for i, name := range []string{"eph-repo-1", "eph-repo-2", "eph-repo-3"} {
+		id := int64(i + 1)
+		if _, err := store.Enqueue(id, 1, "org/repo", "", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.RecordDroplet(id, name, int(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Droplets 1 and 2 are released; 2 is already gone from DO. 3 is busy.
+	for _, id := range []int64{1, 2} {
+		if _, _, err := store.Complete(id, "", "success"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	droplets := []godo.Droplet{
+		{ID: 1, Name: "eph-repo-1", Created: now.Add(-2 * time.Hour).Format(time.RFC3339), Size: &godo.Size{PriceHourly: 0.5}},
+		{ID: 3, Name: "eph-repo-3", Created: now.Format(time.RFC3339)},
+	}
+
+	dry := releasedDroplets(store, droplets, now, true)
+	if len(dry) != 1 || dry[0].ID != 1 {
+		t.Fatalf("dry run removals = %+v, want droplet 1", dry)
+	}
+	if d, _, _ := store.Droplet(2); d.Status != state.DropletReleased {
+		t.Errorf("dry run recorded droplet 2 %s, want it left released", d.Status)
+	}
+
+	got := releasedDroplets(store, droplets, now, false)
+	if len(got) != 1 {
+		t.Fatalf("removals = %+v, want only droplet 1", got)
+	}
+	if r := got[0]; r.ID != 1 || r.Reason != reasonReleased || r.CostUSD < 0.99 || r.CostUSD > 1.01 {
+		t.Errorf("removal = %+v, want droplet 1 released at $1.00", r)
+	}
+	if d, _, _ := store.Droplet(2); d.Status != state.DropletDeleted {
+		t.Errorf("missing released droplet is %s, want deleted", d.Status)
+	}
+	if d, _, _ := store.Droplet(3); d.Status != state.DropletActive {
+		t.Errorf("busy droplet is %s, want active", d.Status)
+	}
+}
+
+func TestOfflineLongEnough(t *testing.T) {
+	runners := []pendingRunner{
+		{removal: removal{Name: "eph-repo-1", Scope: "org/repo"}},
+		{removal: removal{Name: "eph-repo-2", Scope: "org/repo"}},
+	}
+	start := time.Now()
+
+	if got := offlineLongEnough(nil, runners, 0, start, false); len(got) != 2 {
+		t.Errorf("without a minimum got %d runners, want all 2", len(got))
+	}
+	if got := offlineLongEnough(nil, runners, time.Hour, start, false); got != nil {
+		t.Errorf("without a store got %d runners, want none", len(got))
+	}
+
+	store := state.NewMemory()
+	if got := offlineLongEnough(store, runners, time.Hour, start, true); len(got) != 0 {
+		t.Errorf("unseen runners in a dry run: got %d, want none", len(got))
+	}
+	if seen, _ := store.OfflineRunners(); len(seen) != 0 {
+		t.Errorf("dry run recorded sightings %v", seen)
+	}
+
+	if got := offlineLongEnough(store, runners, time.Hour, start, false); len(got) != 0 {
+		t.Errorf("first sighting: got %d runners, want none", len(got))
+	}
+	// eph-repo-2 came back online and is forgotten; eph-repo-1 is now due.
+	later := start.Add(time.Hour)
+	got := offlineLongEnough(store, runners[:1], time.Hour, later, true)
+	if len(got) != 1 || got[0].Name != "eph-repo-1" {
+		t.Errorf("dry run after an hour got %+v, want eph-repo-1", got)
+	}
+	if got := offlineLongEnough(store, runners[:1], time.Hour, later, false); len(got) != 1 {
+		t.Errorf("after an hour got %d runners, want 1", len(got))
+	}
+	if got := offlineLongEnough(store, runners[1:], time.Hour, later.Add(time.Minute), false); len(got) != 0 {
+		t.Errorf("runner back online restarted its clock, got %d runners, want none", len(got))
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/digitalocean/godo"
+	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/webhook"
+)
+
+// Removal reasons.
+const (
+	reasonReleased = "released" // job completed, droplet not yet deleted
+	reasonAge      = "age"      // droplet older than the max age
+	reasonOffline  = "offline"  // runner offline, not created by the listener
+	reasonOrphaned = "orphaned" // listener-created runner offline without a live droplet
+)
+
+// removal is a droplet or runner cleanup removes, or would remove in a dry run.
+type removal struct {
+	Kind    string    `json:"kind"` // "droplet" or "runner"
+	Name    string    `json:"name"`
+	ID      int64     `json:"id"`
+	Scope   string    `json:"scope,omitempty"` // owner/repo or org a runner is registered with
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created,omitzero"`
+	CostUSD float64   `json:"cost_usd"` // accrued since creation at the droplet's hourly price
+	Removed bool      `json:"removed"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func dropletRemoval(d godo.Droplet, reason string, now time.Time) removal {
+	created, _ := time.Parse(time.RFC3339, d.Created)
+	r := removal{Kind: "droplet", Name: d.Name, ID: int64(d.ID), Reason: reason, Created: created}
+	if d.Size != nil && !created.IsZero() {
+		r.CostUSD = now.Sub(created).Hours() * d.Size.PriceHourly
+	}
+	return r
+}
+
+func runnerRemoval(r gh.Runner, scope string) removal {
+	reason := reasonOffline
+	if webhook.EphemeralRunnerName(r.Name) {
+		reason = reasonOrphaned
+	}
+	return removal{Kind: "runner", Name: r.Name, ID: r.ID, Scope: scope, Reason: reason}
+}
+
+// report collects the removals of one cleanup run.
+type report struct {
+	DryRun   bool      `json:"dry_run"`
+	Removals []removal `json:"removals"`
+}
+
+func (r *report) add(rm removal) {
+	r.Removals = append(r.Removals, rm)
+}
+
+// write prints the report to w as "json" or a "table".
+func (r *report) write(w io.Writer, format string) error {
+	if format == "json" {
+		if r.Removals == nil {
+			r.Removals = []removal{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "KIND\tNAME\tID\tSCOPE\tREASON\tCREATED\tCOST\tRESULT")
+	var total float64
+	for _, rm := range r.Removals {
+		created := "-"
+		if !rm.Created.IsZero() {
+			created = rm.Created.UTC().Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t$%.2f\t%s\n",
+			rm.Kind, rm.Name, rm.ID, orDash(rm.Scope), rm.Reason, created, rm.CostUSD, r.result(rm))
+		total += rm.CostUSD
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d droplets and runners, $%.2f accrued\n", len(r.Removals), total)
+	return err
+}
+
+func (r *report) result(rm removal) string {
+	switch {
+	case r.DryRun:
+		return "dry-run"
+	case rm.Removed:
+		return "removed"
+	default:
+		return "failed: " + strings.ReplaceAll(rm.Error, "\t", " ")
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportTable(t *testing.T) {
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := &report{}
+	r.add(removal{Kind: "droplet", Name: "eph-repo-1", ID: 11, Reason: reasonReleased, Created: created, CostUSD: 1.25, Removed: true})
+	r.add(removal{Kind: "runner", Name: "old-box", ID: 7, Scope: "org/repo", Reason: reasonOffline, Error: "HTTP 500:\tboom"})
+
+	var buf bytes.Buffer
+	if err := r.write(&buf, "table"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines, want header, 2 rows, blank and total:\n%s", len(lines), buf.String())
+	}
+	if !strings.HasPrefix(lines[0], "KIND") || !strings.HasSuffix(lines[0], "RESULT") {
+		t.Errorf("header = %q", lines[0])
+	}
+	for _, want := range []string{"eph-repo-1", "11", "2025-03-01T12:00:00Z", "$1.25", "removed"} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("droplet row %q missing %q", lines[1], want)
+		}
+	}
+	if !strings.Contains(lines[2], " -  ") || !strings.HasSuffix(lines[2], "$0.00  failed: HTTP 500: boom") {
+		t.Errorf("runner row %q: want no created time, no cost and the failure", lines[2])
+	}
+	if want := "2 droplets and runners, $1.25 accrued"; lines[4] != want {
+		t.Errorf("total = %q, want %q", lines[4], want)
+	}
+}
+
+func TestReportTableDryRun(t *testing.T) {
+	r := &report{DryRun: true}
+	r.add(removal{Kind: "droplet", Name: "eph-repo-1", ID: 11, Reason: reasonAge})
+
+	var buf bytes.Buffer
+	if err := r.write(&buf, "table"); err != nil {
+		t.Fatal(err)
+	}
+	if row := strings.Split(buf.String(), "\n")[1]; !strings.HasSuffix(strings.TrimSpace(row), "dry-run") {
+		t.Errorf("row = %q, want dry-run result", row)
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (&report{DryRun: true}).write(&buf, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["dry_run"] != true {
+		t.Errorf("dry_run = %v, want true", got["dry_run"])
+	}
+	if removals, ok := got["removals"].([]any); !ok || len(removals) != 0 {
+		t.Errorf("removals = %v, want an empty list", got["removals"])
+	}
+
+	buf.Reset()
+	r := &report{}
+	r.add(removal{Kind: "runner", Name: "eph-repo-1", ID: 7, Scope: "org", Reason: reasonOrphaned, Removed: true})
+	if err := r.write(&buf, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Removals) != 1 || decoded.Removals[0] != r.Removals[0] {
+		t.Errorf("decoded %+v, want %+v", decoded.Removals, r.Removals)
+	}
+	if strings.Contains(buf.String(), "created") {
+		t.Errorf("zero created time was encoded:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/pool"
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+	"github.com/thomasvincent/github-runners-infra/internal/webhook"
+)
+
+// renderCloudInit renders the cloud-init template the listener would give a
+// runner, with placeholders for the secrets only the listener mints, so a
+// template can be checked before it is deployed.
+func renderCloudInit(_ context.Context, args []string) error {
+	fs, output := newFlags("render-cloud-init")
+	tmplPath := fs.String("template", envOrDefault("CLOUD_INIT_PATH", "cloud-init/runner.yaml.tmpl"), "cloud-init template")
+	poolName := fs.String("pool", "", "render the template and TTL of this pool from POOLS_FILE")
+	name := fs.String("name", "eph-sample-1", "runner name")
+	repo := fs.String("repo", "org/repo", "repository the runner registers with, as owner/name")
+	ttl := fs.Duration("ttl", 0, "runner lifetime (default: the pool's, or 60m)")
+	jitConfig := fs.String("jitconfig", "JITCONFIG", "encoded just-in-time runner config")
+	version := fs.String("runner-version", webhook.DefaultRunnerVersion, "actions/runner release")
+	if err := parseFlags(fs, output, args); err != nil {
+		return err
+	}
+	owner, _, ok := strings.Cut(*repo, "/")
+	if !ok {
+		return fmt.Errorf("invalid --repo %q, want owner/name", *repo)
+	}
+
+	tmpl, poolTTL, err := loadTemplate(*tmplPath, *poolName)
+	if err != nil {
+		return err
+	}
+	if *ttl == 0 {
+		*ttl = poolTTL
+	}
+
+	params := provider.RunnerParams{
+		RunnerName:    *name,
+		JITConfig:     *jitConfig,
+		RunnerOrg:     owner,
+		RunnerRepo:    *repo,
+		CallbackURL:   envOrDefault("PUBLIC_URL", "https://runners.example.com") + "/self-destruct?runner=" + *name,
+		CallbackToken: "CALLBACK_TOKEN",
+		RunnerVersion: *version,
+		TraceURL:      os.Getenv("RUNNER_TRACE_URL"),
+		TTL:           *ttl,
+
+		GitHubURL:         (&gh.App{WebURL: os.Getenv("GITHUB_WEB_URL")}).ServerURL(),
+		RunnerDownloadURL: envOrDefault("RUNNER_DOWNLOAD_URL", webhook.DefaultRunnerDownloadURL),
+	}
+	var userData bytes.Buffer
+	if err := tmpl.Execute(&userData, params); err != nil {
+		return fmt.Errorf("render cloud-init: %w", err)
+	}
+
+	if *output == "json" {
+		return write(os.Stdout, "json", struct {
+			UserData string `json:"user_data"`
+		}{userData.String()}, nil)
+	}
+	_, err = os.Stdout.Write(userData.Bytes())
+	return err
+}
+
+// loadTemplate returns the template of the named pool, or the one at path
+// for the default pool or pools without their own.
+func loadTemplate(path, poolName string) (*template.Template, time.Duration, error) {
+	var ttl time.Duration
+	if poolName != "" {
+		poolsPath, err := requireEnv("POOLS_FILE")
+		if err != nil {
+			return nil, 0, err
+		}
+		pools, err := pool.Load(poolsPath)
+		if err != nil {
+			return nil, 0, err
+		}
+		p, ok := pools.Get(poolName)
+		if !ok {
+			return nil, 0, fmt.Errorf("no pool %q in %s", poolName, poolsPath)
+		}
+		if tmpl := p.Template(); tmpl != nil {
+			return tmpl, p.RunnerTTL(), nil
+		}
+		ttl = p.RunnerTTL()
+	}
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("parse cloud-init template: %w", err)
+	}
+	return tmpl, ttl, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/thomasvincent/github-runners-infra/internal/state"
+)
+
+// droplet is a runner droplet as listed by droplets ls.
+type droplet struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	Region      string    `json:"region,omitempty"`
+	Size        string    `json:"size,omitempty"`
+	Created     time.Time `json:"created,omitzero"`
+	PriceHourly float64   `json:"price_hourly"`
+	Tags        []string  `json:"tags,omitempty"`
+}
+
+func newDroplet(d godo.Droplet) droplet {
+	created, _ := time.Parse(time.RFC3339, d.Created)
+	out := droplet{ID: d.ID, Name: d.Name, Status: d.Status, Created: created, Tags: d.Tags}
+	if d.Region != nil {
+		out.Region = d.Region.Slug
+	}
+	if d.Size != nil {
+		out.Size = d.Size.Slug
+		out.PriceHourly = d.Size.PriceHourly
+	}
+	return out
+}
+
+// dropletsLs lists every droplet tagged github-runner.
+func dropletsLs(ctx context.Context, args []string) error {
+	fs, output := newFlags("droplets ls")
+	if err := parseFlags(fs, output, args); err != nil {
+		return err
+	}
+	client, err := newDOClient()
+	if err != nil {
+		return err
+	}
+
+	list, err := client.ListRunnerDroplets(ctx)
+	if err != nil {
+		return err
+	}
+	droplets := make([]droplet, 0, len(list))
+	t := &table{header: []string{"ID", "NAME", "STATUS", "REGION", "SIZE", "CREATED", "$/HR", "TAGS"}}
+	for _, d := range list {
+		dr := newDroplet(d)
+		droplets = append(droplets, dr)
+		t.add(strconv.Itoa(dr.ID), dr.Name, dr.Status, dr.Region, dr.Size, formatTime(dr.Created),
+			fmt.Sprintf("%.4f", dr.PriceHourly), strings.Join(dr.Tags, ","))
+	}
+	return write(os.Stdout, *output, droplets, t)
+}
+
+// dropletsRm deletes the droplets with the given IDs and, with STATE_PATH
+// set, records them deleted in the state store. Droplets not tagged
+// github-runner are refused unless --force is given.
+func dropletsRm(ctx context.Context, args []string) error {
+	fs, output := newFlags("droplets rm")
+	force := fs.Bool("force", false, "also delete droplets not tagged github-runner")
+	if err := parseFlags(fs, output, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("no droplet IDs given")
+	}
+	ids := make([]int, fs.NArg())
+	for i, arg := range fs.Args() {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid droplet ID %q", arg)
+		}
+		ids[i] = id
+	}
+	client, err := newDOClient()
+	if err != nil {
+		return err
+	}
+	var store *state.Store
+	if path := os.Getenv("STATE_PATH"); path != "" {
+		if store, err = state.Open(path); err != nil {
+			return err
+		}
+	}
+	runners := make(map[int]bool)
+	if !*force {
+		list, err := client.ListRunnerDroplets(ctx)
+		if err != nil {
+			return err
+		}
+		for _, d := range list {
+			runners[d.ID] = true
+		}
+	}
+
+	results := make([]result, 0, len(ids))
+	t := &table{header: []string{"ID", "RESULT"}}
+	for _, id := range ids {
+		r := result{ID: int64(id)}
+		if !*force && !runners[id] {
+			r.Error = "not a runner droplet, use --force to delete it anyway"
+		} else if err := client.DeleteDroplet(ctx, id); err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Removed = true
+			slog.Info("Deleted droplet", "droplet_id", id)
+			if store != nil {
+				if err := store.MarkDropletDeleted(id); err != nil {
+					slog.Error("Failed to record droplet deleted", "droplet_id", id, "error", err)
+				}
+			}
+		}
+		results = append(results, r)
+		t.add(strconv.Itoa(id), r.status(false))
+	}
+	if err := write(os.Stdout, *output, results, t); err != nil {
+		return err
+	}
+	return failures(results)
+}
@@ -0,0 +1,255 @@
+// Command runnersctl inspects and manages runner droplets and GitHub runners
+// from an operator's shell. It reads the same environment as the listener
+// and the cleanup job.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thomasvincent/github-runners-infra/internal/digitalocean"
+	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+)
+
+const usage = `usage: runnersctl <command> [flags]
+
+commands:
+  droplets ls                      list runner droplets
+  droplets rm ID...                delete runner droplets
+  runners ls --repo OWNER/NAME     list a repo's (or --org's) self-hosted runners
+  runners prune --repo OWNER/NAME  deregister a repo's (or --org's) offline runners
+  token repo OWNER/NAME            mint a runner registration token for a repo
+  render-cloud-init                render the cloud-init template for a runner
+  replay-delivery ID               ask GitHub to redeliver an app webhook delivery
+  replay-delivery --file PAYLOAD   sign a saved payload and post it to the listener
+                                   (rejected when the listener has a hook allowlist)
+
+Every command accepts --output table|json. Run a command with -h for its flags.
+`
+
+// command runs one subcommand with the arguments that follow its name.
+type command func(ctx context.Context, args []string) error
+
+var commands = map[string]command{
+	"droplets ls":       dropletsLs,
+	"droplets rm":       dropletsRm,
+	"runners ls":        runnersLs,
+	"runners prune":     runnersPrune,
+	"token repo":        tokenRepo,
+	"render-cloud-init": renderCloudInit,
+	"replay-delivery":   replayDelivery,
+}
+
+func main() {
+	// Keep stdout for command output so it can be piped
+	logger := newLogger(os.Getenv("LOG_LEVEL"), os.Stderr)
+	slog.SetDefault(logger)
+
+	name, cmd, args := lookup(os.Args[1:])
+	if cmd == nil {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	if err := cmd(ctx, args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fatal("Command failed", "command", name, "error", err)
+	}
+}
+
+// lookup finds the command named by the first one or two arguments.
+func lookup(args []string) (string, command, []string) {
+	if len(args) >= 2 {
+		name := args[0] + " " + args[1]
+		if cmd, ok := commands[name]; ok {
+			return name, cmd, args[2:]
+		}
+	}
+	if len(args) >= 1 {
+		if cmd, ok := commands[args[0]]; ok {
+			return args[0], cmd, args[1:]
+		}
+	}
+	return "", nil, nil
+}
+
+// newFlags returns the flag set of a command, with the --output flag every
+// command shares.
+func newFlags(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet("runnersctl "+name, flag.ContinueOnError)
+	output := fs.String("output", "table", "print results as table or json")
+	return fs, output
+}
+
+// parseFlags parses args into fs and checks the output format. Unlike
+// fs.Parse, it accepts flags after positional arguments, as in
+// "droplets rm 123 --force"; arguments after "--" are all positional.
+func parseFlags(fs *flag.FlagSet, output *string, args []string) error {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+		if i := len(args) - len(rest); i > 0 && args[i-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	// Parsing stops at "--", leaving the positional arguments in fs.Args().
+	if err := fs.Parse(append([]string{"--"}, positional...)); err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("invalid --output %q, want table or json", *output)
+	}
+	return nil
+}
+
+// newDOClient returns a DigitalOcean client for listing and deleting
+// droplets.
+func newDOClient() (*digitalocean.Client, error) {
+	token, err := requireEnv("DIGITALOCEAN_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	return digitalocean.NewClient(digitalocean.Config{Token: token, Logger: slog.Default()})
+}
+
+// newGitHubApp returns the GitHub App configured by APP_ID and
+// APP_PRIVATE_KEY_FILE.
+func newGitHubApp() (*gh.App, error) {
+	appIDStr, err := requireEnv("APP_ID")
+	if err != nil {
+		return nil, err
+	}
+	appID, err := strconv.ParseInt(appIDStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APP_ID: %w", err)
+	}
+	keyPath, err := requireEnv("APP_PRIVATE_KEY_FILE")
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("read private key: %w", err)
+	}
+	return &gh.App{
+		AppID:         appID,
+		PrivateKey:    privateKey,
+		KeyPassphrase: []byte(os.Getenv("APP_PRIVATE_KEY_PASSPHRASE")),
+		APIURL:        os.Getenv("GITHUB_API_URL"),
+		WebURL:        os.Getenv("GITHUB_WEB_URL"),
+		Logger:        slog.Default(),
+	}, nil
+}
+
+// scopeFlags registers the --repo and --org flags of commands acting on the
+// runners of one repo or org.
+func scopeFlags(fs *flag.FlagSet) (repo, org *string) {
+	return fs.String("repo", "", "repository, as owner/name"), fs.String("org", "", "organization")
+}
+
+// runnerScope is the repo or org a runner command acts on, and the app
+// installation that covers it.
+type runnerScope struct {
+	owner, repo  string // repo is empty for an org
+	installation int64
+}
+
+func (s runnerScope) String() string {
+	if s.repo == "" {
+		return s.owner
+	}
+	return s.owner + "/" + s.repo
+}
+
+// resolveScope checks that exactly one of repo and org is set and looks up
+// the installation of its owner.
+func resolveScope(ctx context.Context, app *gh.App, repo, org string) (runnerScope, error) {
+	var s runnerScope
+	switch {
+	case repo != "" && org == "":
+		owner, name, ok := strings.Cut(repo, "/")
+		if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			return s, fmt.Errorf("invalid --repo %q, want owner/name", repo)
+		}
+		s.owner, s.repo = owner, name
+	case repo == "" && org != "":
+		s.owner = org
+	default:
+		return s, errors.New("exactly one of --repo and --org is required")
+	}
+
+	id, err := app.AccountInstallation(ctx, s.owner)
+	if err != nil {
+		return s, err
+	}
+	s.installation = id
+	return s, nil
+}
+
+func (s runnerScope) listRunners(ctx context.Context, app *gh.App) ([]gh.Runner, error) {
+	ctx = gh.WithInstallation(ctx, s.installation)
+	if s.repo == "" {
+		return app.ListOrgRunners(ctx, s.owner)
+	}
+	return app.ListRepoRunners(ctx, s.owner, s.repo)
+}
+
+func (s runnerScope) removeRunner(ctx context.Context, app *gh.App, id int64) error {
+	ctx = gh.WithInstallation(ctx, s.installation)
+	if s.repo == "" {
+		return app.RemoveOrgRunner(ctx, s.owner, id)
+	}
+	return app.RemoveRepoRunner(ctx, s.owner, s.repo, id)
+}
+
+func requireEnv(key string) (string, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return "", fmt.Errorf("%s is required", key)
+	}
+	return v, nil
+}
+
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// newLogger returns a JSON logger on w at the given level (debug, info, warn
+// or error; info if empty or unknown).
+func newLogger(level string, w io.Writer) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: lvl}))
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
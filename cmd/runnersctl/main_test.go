@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestParseFlagsAfterArgs(t *testing.T) {
+	tests := []struct {
+		args      []string
+		force     bool
+		output    string
+		wantArgs  []string
+		wantError bool
+	}{
+		{[]string{"--force", "123", "456"}, true, "table", []string{"123", "456"}, false},
+		{[]string{"123", "--force"}, true, "table", []string{"123"}, false},
+		{[]string{"123", "--output", "json", "456"}, false, "json", []string{"123", "456"}, false},
+		{[]string{"123", "--", "--force"}, false, "table", []string{"123", "--force"}, false},
+		{[]string{"123", "--frce"}, false, "table", nil, true},
+		{[]string{"123", "--output", "yaml"}, false, "", nil, true},
+	}
+	for _, tt := range tests {
+		fs, output := newFlags("droplets rm")
+		fs.SetOutput(io.Discard)
+		force := fs.Bool("force", false, "")
+		err := parseFlags(fs, output, tt.args)
+		if tt.wantError {
+			if err == nil {
+				t.Errorf("parseFlags(%q) succeeded, want an error", tt.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFlags(%q) error = %v", tt.args, err)
+			continue
+		}
+		if *force != tt.force || *output != tt.output || !slices.Equal(fs.Args(), tt.wantArgs) {
+			t.Errorf("parseFlags(%q) = force %v, output %q, args %q; want %v, %q, %q",
+				tt.args, *force, *output, fs.Args(), tt.force, tt.output, tt.wantArgs)
+		}
+	}
+}
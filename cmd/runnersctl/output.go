@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// table is command output as rows under a header.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func (t *table) add(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// write prints v as indented JSON, or t as an aligned table.
+func write(w io.Writer, format string, v any, t *table) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+	for _, row := range t.rows {
+		for i, cell := range row {
+			row[i] = orDash(strings.ReplaceAll(cell, "\t", " "))
+		}
+		_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// result is the outcome of removing one droplet or runner.
+type result struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name,omitempty"`
+	Scope   string `json:"scope,omitempty"`
+	Removed bool   `json:"removed"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (r result) status(dryRun bool) string {
+	switch {
+	case dryRun:
+		return "dry-run"
+	case r.Removed:
+		return "removed"
+	default:
+		return "failed: " + r.Error
+	}
+}
+
+// failures returns an error if any removal failed.
+func failures(results []result) error {
+	failed := 0
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d removals failed", failed, len(results))
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWriteTable(t *testing.T) {
+	tbl := &table{header: []string{"ID", "NAME", "RESULT"}}
+	tbl.add("1", "eph-repo-1", "removed")
+	tbl.add("22", "", "failed: HTTP 500:\tboom")
+
+	var buf bytes.Buffer
+	if err := write(&buf, "table", nil, tbl); err != nil {
+		t.Fatal(err)
+	}
+	want := "ID  NAME        RESULT\n" +
+		"1   eph-repo-1  removed\n" +
+		"22  -           failed: HTTP 500: boom\n"
+	if buf.String() != want {
+		t.Errorf("table =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	results := []result{{ID: 1, Name: "eph-repo-1", Removed: true}, {ID: 2, Error: "boom"}}
+
+	var buf bytes.Buffer
+	if err := write(&buf, "json", results, nil); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0]["name"] != "eph-repo-1" || got[0]["removed"] != true || got[1]["error"] != "boom" {
+		t.Errorf("json = %s", buf.String())
+	}
+	if _, ok := got[1]["name"]; ok {
+		t.Errorf("empty name was encoded: %s", buf.String())
+	}
+}
+
+func TestResultStatus(t *testing.T) {
+	tests := []struct {
+		r      result
+		dryRun bool
+		want   string
+	}{
+		{result{}, true, "dry-run"},
+		{result{Removed: true}, false, "removed"},
+		{result{Error: "HTTP 404"}, false, "failed: HTTP 404"},
+	}
+	for _, tt := range tests {
+		if got := tt.r.status(tt.dryRun); got != tt.want {
+			t.Errorf("%+v.status(%v) = %q, want %q", tt.r, tt.dryRun, got, tt.want)
+		}
+	}
+
+	if err := failures([]result{{Removed: true}}); err != nil {
+		t.Errorf("failures without errors = %v", err)
+	}
+	if err := failures([]result{{Removed: true}, {Error: "boom"}}); err == nil || err.Error() != "1 of 2 removals failed" {
+		t.Errorf("failures = %v, want 1 of 2", err)
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	if got := formatTime(time.Time{}); got != "" {
+		t.Errorf("zero time = %q, want empty", got)
+	}
+	at := time.Date(2025, 3, 1, 13, 0, 0, 0, time.FixedZone("CET", 3600))
+	if got := formatTime(at); got != "2025-03-01T12:00:00Z" {
+		t.Errorf("formatTime = %q, want UTC", got)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/thomasvincent/github-runners-infra/internal/webhook"
+)
+
+// replayDelivery asks GitHub to redeliver an app webhook delivery by ID, or
+// with --file posts a saved payload to the listener, signed with the current
+// webhook secret, as GitHub would.
+func replayDelivery(ctx context.Context, args []string) error {
+	fs, output := newFlags("replay-delivery")
+	file := fs.String("file", "", "saved payload to post to the listener instead of asking GitHub")
+	url := fs.String("url", "http://localhost:8080/webhook", "listener endpoint, with --file")
+	event := fs.String("event", "workflow_job", "X-GitHub-Event header, with --file")
+	guid := fs.String("guid", "", "X-GitHub-Delivery header, with --file (default: random)")
+	if err := parseFlags(fs, output, args); err != nil {
+		return err
+	}
+
+	if *file != "" {
+		if fs.NArg() != 0 {
+			return errors.New("a delivery ID and --file are mutually exclusive")
+		}
+		return postPayload(ctx, *output, *file, *url, *event, *guid)
+	}
+	if fs.NArg() != 1 {
+		return errors.New("want exactly one delivery ID, or --file")
+	}
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid delivery ID %q", fs.Arg(0))
+	}
+	app, err := newGitHubApp()
+	if err != nil {
+		return err
+	}
+	if err := app.RedeliverHook(ctx, id); err != nil {
+		return err
+	}
+
+	out := struct {
+		Delivery    int64 `json:"delivery"`
+		Redelivered bool  `json:"redelivered"`
+	}{id, true}
+	t := &table{header: []string{"DELIVERY", "RESULT"}}
+	t.add(strconv.FormatInt(id, 10), "redelivery requested")
+	return write(os.Stdout, *output, out, t)
+}
+
+// postPayload signs the payload in path and posts it to url.
+func postPayload(ctx context.Context, output, path, url, event, guid string) error {
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read payload: %w", err)
+	}
+	secret, err := webhookSecret()
+	if err != nil {
+		return err
+	}
+	if guid == "" {
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		guid = "replay-" + hex.EncodeToString(b)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-GitHub-Delivery", guid)
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("post payload: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	out := struct {
+		Delivery string `json:"delivery"`
+		Status   int    `json:"status"`
+		Response string `json:"response,omitempty"`
+	}{guid, resp.StatusCode, string(bytes.TrimSpace(body))}
+	t := &table{header: []string{"DELIVERY", "STATUS", "RESPONSE"}}
+	t.add(out.Delivery, strconv.Itoa(out.Status), out.Response)
+	if err := write(os.Stdout, output, out, t); err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		// The hook allowlist admits only GitHub's addresses, not this host.
+		return fmt.Errorf("listener answered %s; with HOOK_ALLOWLIST_URL or HOOK_ALLOWLIST_FILE set it rejects payloads not sent by GitHub, use replay-delivery ID instead", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("listener answered %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookSecret returns the secret the listener signs with first: the first
+// entry of WEBHOOK_SECRETS_FILE, or WEBHOOK_SECRET.
+func webhookSecret() ([]byte, error) {
+	if path := os.Getenv("WEBHOOK_SECRETS_FILE"); path != "" {
+		secrets, err := webhook.LoadWebhookSecrets(path)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(secrets[0].Secret), nil
+	}
+	secret, err := requireEnv("WEBHOOK_SECRET")
+	return []byte(secret), err
+}
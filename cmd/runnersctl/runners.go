@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+
+	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/webhook"
+)
+
+// runnersLs lists the self-hosted runners of a repo or org.
+func runnersLs(ctx context.Context, args []string) error {
+	fs, output := newFlags("runners ls")
+	repo, org := scopeFlags(fs)
+	if err := parseFlags(fs, output, args); err != nil {
+		return err
+	}
+	app, err := newGitHubApp()
+	if err != nil {
+		return err
+	}
+	scope, err := resolveScope(ctx, app, *repo, *org)
+	if err != nil {
+		return err
+	}
+
+	runners, err := scope.listRunners(ctx, app)
+	if err != nil {
+		return err
+	}
+	if runners == nil {
+		runners = []gh.Runner{}
+	}
+	t := &table{header: []string{"ID", "NAME", "STATUS", "BUSY"}}
+	for _, r := range runners {
+		t.add(strconv.FormatInt(r.ID, 10), r.Name, r.Status, strconv.FormatBool(r.Busy))
+	}
+	return write(os.Stdout, *output, runners, t)
+}
+
+// runnersPrune deregisters the offline runners of a repo or org. Only
+// runners the listener created are touched unless --all is given, and with
+// DIGITALOCEAN_TOKEN set, runners whose droplet still exists (and may be
+// booting) are kept.
+func runnersPrune(ctx context.Context, args []string) error {
+	fs, output := newFlags("runners prune")
+	repo, org := scopeFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "list the runners that would be removed without removing them")
+	all := fs.Bool("all", false, "also remove offline runners registered by hand")
+	if err := parseFlags(fs, output, args); err != nil {
+		return err
+	}
+	app, err := newGitHubApp()
+	if err != nil {
+		return err
+	}
+	scope, err := resolveScope(ctx, app, *repo, *org)
+	if err != nil {
+		return err
+	}
+
+	live := make(map[string]bool)
+	if os.Getenv("DIGITALOCEAN_TOKEN") != "" {
+		client, err := newDOClient()
+		if err != nil {
+			return err
+		}
+		droplets, err := client.ListRunnerDroplets(ctx)
+		if err != nil {
+			return err
+		}
+		for _, d := range droplets {
+			live[d.Name] = true
+		}
+	} else {
+		slog.Warn("DIGITALOCEAN_TOKEN not set, runners of booting droplets may be removed")
+	}
+
+	runners, err := scope.listRunners(ctx, app)
+	if err != nil {
+		return err
+	}
+
+	results := []result{}
+	t := &table{header: []string{"ID", "NAME", "SCOPE", "RESULT"}}
+	for _, r := range pruneCandidates(runners, live, *all) {
+		res := result{ID: r.ID, Name: r.Name, Scope: scope.String()}
+		if !*dryRun {
+			if err := scope.removeRunner(ctx, app, r.ID); err != nil {
+				res.Error = err.Error()
+			} else {
+				res.Removed = true
+				slog.Info("Removed offline runner", "runner_name", r.Name, "runner_id", r.ID, "scope", res.Scope)
+			}
+		}
+		results = append(results, res)
+		t.add(strconv.FormatInt(r.ID, 10), r.Name, res.Scope, res.status(*dryRun))
+	}
+	if err := write(os.Stdout, *output, results, t); err != nil {
+		return err
+	}
+	return failures(results)
+}
+
+// pruneCandidates returns the offline runners to deregister: those the
+// listener created, or with all every one, except runners of the live
+// droplets.
+func pruneCandidates(runners []gh.Runner, live map[string]bool, all bool) []gh.Runner {
+	return gh.OfflineRunners(runners, func(r gh.Runner) bool {
+		return live[r.Name] || (!all && !webhook.EphemeralRunnerName(r.Name))
+	})
+}
+
+// tokenRepo mints a registration token for adding a runner to a repo by hand.
+func tokenRepo(ctx context.Context, args []string) error {
+	fs, output := newFlags("token repo")
+	if err := parseFlags(fs, output, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("want exactly one repository, as owner/name")
+	}
+	app, err := newGitHubApp()
+	if err != nil {
+		return err
+	}
+	scope, err := resolveScope(ctx, app, fs.Arg(0), "")
+	if err != nil {
+		return err
+	}
+
+	token, err := app.GenerateRepoRunnerToken(gh.WithInstallation(ctx, scope.installation), scope.owner, scope.repo)
+	if err != nil {
+		return err
+	}
+	out := struct {
+		Repo  string `json:"repo"`
+		Token string `json:"token"`
+	}{scope.String(), token}
+	t := &table{header: []string{"REPO", "TOKEN"}}
+	t.add(out.Repo, out.Token)
+	return write(os.Stdout, *output, out, t)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+)
+
+func TestPruneCandidates(t *testing.T) {
+	runners := []gh.Runner{
+		{ID: 1, Name: "eph-repo-1-100", Status: "offline"},
+		{ID: 2, Name: "eph-repo-2-100", Status: "offline"}, // droplet still booting
+		{ID: 3, Name: "warm-default-1", Status: "offline"},
+		{ID: 4, Name: "eph-repo-4-100", Status: "online"},
+		{ID: 5, Name: "build-box", Status: "offline"}, // registered by hand
+		{ID: 6, Name: "build-box-2", Status: "online"},
+	}
+	live := map[string]bool{"eph-repo-2-100": true}
+
+	tests := []struct {
+		name string
+		live map[string]bool
+		all  bool
+		want []int64
+	}{
+		{"listener runners", nil, false, []int64{1, 2, 3}},
+		{"keeps live droplets", live, false, []int64{1, 3}},
+		{"all", live, true, []int64{1, 3, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int64
+			for _, r := range pruneCandidates(runners, tt.live, tt.all) {
+				got = append(got, r.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("pruneCandidates = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
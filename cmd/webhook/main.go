@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"strconv"
@@ -11,70 +15,235 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/thomasvincent/github-runners-infra/internal/cleanup"
 	"github.com/thomasvincent/github-runners-infra/internal/digitalocean"
 	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/local"
+	"github.com/thomasvincent/github-runners-infra/internal/metrics"
+	"github.com/thomasvincent/github-runners-infra/internal/pool"
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+	"github.com/thomasvincent/github-runners-infra/internal/state"
+	"github.com/thomasvincent/github-runners-infra/internal/tracing"
 	"github.com/thomasvincent/github-runners-infra/internal/webhook"
 )
 
+// reloadInterval is how often APP_PRIVATE_KEY_FILE and WEBHOOK_SECRETS_FILE
+// are checked for changes.
+const reloadInterval = 30 * time.Second
+
 func main() {
+	logger := newLogger(os.Getenv("LOG_LEVEL"))
+	slog.SetDefault(logger)
+
 	appID, err := strconv.ParseInt(mustEnv("APP_ID"), 10, 64)
 	if err != nil {
-		log.Fatalf("Invalid APP_ID: %v", err)
+		fatal("Invalid APP_ID", "error", err)
 	}
 
-	installID, err := strconv.ParseInt(mustEnv("APP_INSTALLATION_ID"), 10, 64)
+	// Deliveries name their installation, so one listener serves every org
+	// the app is installed on. APP_INSTALLATION_ID is only a fallback for
+	// payloads without one; APP_INSTALLATION_IDS restricts which are served.
+	var installID int64
+	if s := os.Getenv("APP_INSTALLATION_ID"); s != "" {
+		installID, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			fatal("Invalid APP_INSTALLATION_ID", "error", err)
+		}
+	}
+	installations, err := gh.ParseInstallationIDs(os.Getenv("APP_INSTALLATION_IDS"))
 	if err != nil {
-		log.Fatalf("Invalid APP_INSTALLATION_ID: %v", err)
+		fatal("Invalid APP_INSTALLATION_IDS", "error", err)
 	}
 
 	// Only support file-based private key loading (#5)
 	keyPath := mustEnv("APP_PRIVATE_KEY_FILE")
 	privateKey, err := os.ReadFile(keyPath)
 	if err != nil {
-		log.Fatalf("Failed to read private key file %s: %v", keyPath, err)
+		fatal("Failed to read private key file", "path", keyPath, "error", err)
 	}
 
-	webhookSecret := []byte(mustEnv("WEBHOOK_SECRET"))
-	doToken := mustEnv("DIGITALOCEAN_TOKEN")
+	// WEBHOOK_SECRETS_FILE lists the secrets accepted while rotating and is
+	// reloaded when it changes; otherwise WEBHOOK_SECRET is the only one.
+	secretsPath := os.Getenv("WEBHOOK_SECRETS_FILE")
+	var webhookSecret []byte
+	var webhookSecrets []gh.WebhookSecret
+	if secretsPath != "" {
+		webhookSecrets, err = webhook.LoadWebhookSecrets(secretsPath)
+		if err != nil {
+			fatal("Failed to load webhook secrets", "error", err)
+		}
+	} else {
+		webhookSecret = []byte(mustEnv("WEBHOOK_SECRET"))
+	}
+	// Runners call back to PUBLIC_URL to have their droplet deleted; the DO
+	// token never leaves this host. Their tokens are signed with a key of
+	// their own, so rotating the webhook secret leaves them valid.
+	publicURL := mustEnv("PUBLIC_URL")
+	selfDestructSecret := []byte(mustEnv("SELF_DESTRUCT_SECRET"))
 
-	cloudInitPath := envOrDefault("CLOUD_INIT_PATH", "cloud-init/runner.yaml.tmpl")
-	region := envOrDefault("DO_REGION", "nyc3")
-	size := envOrDefault("DO_SIZE", "s-4vcpu-8gb")
 	requiredLabel := envOrDefault("REQUIRED_LABEL", "self-hosted")
 	listenAddr := envOrDefault("LISTEN_ADDR", ":8080")
+	statePath := envOrDefault("STATE_PATH", "/var/lib/github-runners/state.json")
+
+	warmMaxCost, err := strconv.ParseFloat(envOrDefault("WARM_MAX_HOURLY_COST", "0"), 64)
+	if err != nil {
+		fatal("Invalid WARM_MAX_HOURLY_COST", "error", err)
+	}
+
+	// Optional label-based pools; without a file every job matching
+	// REQUIRED_LABEL gets the DO_REGION/DO_SIZE droplet.
+	var pools pool.Set
+	if poolsPath := os.Getenv("POOLS_FILE"); poolsPath != "" {
+		pools, err = pool.Load(poolsPath)
+		if err != nil {
+			fatal("Failed to load pools", "error", err)
+		}
+		slog.Info("Loaded runner pools", "count", len(pools), "path", poolsPath)
+	}
+
+	// Droplets carrying the cleanup policy's exclude tag are never deleted
+	policy := cleanup.Default()
+	if path := os.Getenv("CLEANUP_POLICY_FILE"); path != "" {
+		policy, err = cleanup.Load(path)
+		if err != nil {
+			fatal("Failed to load cleanup policy", "error", err)
+		}
+	}
+
+	dedupTTL, err := time.ParseDuration(envOrDefault("DEDUP_TTL", "1h"))
+	if err != nil {
+		fatal("Invalid DEDUP_TTL", "error", err)
+	}
+
+	// Cross-check droplets against GitHub runners between cleanup timer runs
+	reconcileInterval, err := time.ParseDuration(envOrDefault("RECONCILE_INTERVAL", "5m"))
+	if err != nil {
+		fatal("Invalid RECONCILE_INTERVAL", "error", err)
+	}
+	reconcileGrace, err := time.ParseDuration(envOrDefault("RECONCILE_GRACE", "15m"))
+	if err != nil {
+		fatal("Invalid RECONCILE_GRACE", "error", err)
+	}
+
+	// X-Forwarded-For is believed only from these proxies; Caddy runs on localhost
+	trustedProxies, err := webhook.ParsePrefixes(strings.Split(envOrDefault("TRUSTED_PROXIES", "127.0.0.1,::1"), ","))
+	if err != nil {
+		fatal("Invalid TRUSTED_PROXIES", "error", err)
+	}
+
+	// Optionally accept deliveries only from GitHub's hook ranges, read from a
+	// saved /meta document or fetched (and refreshed) from a /meta endpoint
+	var hookAllowlist []netip.Prefix
+	hookURL := os.Getenv("HOOK_ALLOWLIST_URL")
+	if path := os.Getenv("HOOK_ALLOWLIST_FILE"); path != "" {
+		hookAllowlist, err = webhook.LoadHookAllowlist(path)
+		if err != nil {
+			fatal("Failed to load hook allowlist", "error", err)
+		}
+	}
+	if hookURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		fetched, err := webhook.FetchHookAllowlist(ctx, hookURL)
+		cancel()
+		switch {
+		case err == nil:
+			hookAllowlist = fetched
+		case hookAllowlist == nil:
+			fatal("Failed to fetch hook allowlist", "url", hookURL, "error", err)
+		default:
+			slog.Warn("Failed to fetch hook allowlist, using HOOK_ALLOWLIST_FILE until refreshed", "url", hookURL, "error", err)
+		}
+	}
+	hookRefresh, err := time.ParseDuration(envOrDefault("HOOK_ALLOWLIST_REFRESH", "1h"))
+	if err != nil {
+		fatal("Invalid HOOK_ALLOWLIST_REFRESH", "error", err)
+	}
 
-	var sshFingerprints []string
-	if fp := os.Getenv("DO_SSH_FINGERPRINTS"); fp != "" {
-		sshFingerprints = strings.Split(fp, ",")
+	// Spans go to the OTLP collector at OTEL_EXPORTER_OTLP_ENDPOINT, if set
+	shutdownTracing, err := tracing.Setup(context.Background(), "github-runners-webhook", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"))
+	if err != nil {
+		fatal("Failed to set up tracing", "error", err)
 	}
 
+	// Count GitHub API calls by status and trace each one
+	gh.HTTPClient.Transport = tracing.Transport("github", metrics.InstrumentGitHub(gh.HTTPClient.Transport))
+
+	// GitHub Enterprise Server: GITHUB_API_URL=https://HOST/api/v3, GITHUB_WEB_URL=https://HOST
 	githubApp := &gh.App{
 		AppID:          appID,
 		InstallationID: installID,
 		PrivateKey:     privateKey,
+		KeyPassphrase:  []byte(os.Getenv("APP_PRIVATE_KEY_PASSPHRASE")),
+		APIURL:         os.Getenv("GITHUB_API_URL"),
+		WebURL:         os.Getenv("GITHUB_WEB_URL"),
+		Logger:         logger,
+	}
+	// During a key rotation, the key GitHub may still (or already) expect
+	if prevPath := os.Getenv("APP_PREVIOUS_PRIVATE_KEY_FILE"); prevPath != "" {
+		githubApp.PreviousKey, err = os.ReadFile(prevPath)
+		if err != nil {
+			fatal("Failed to read previous private key file", "path", prevPath, "error", err)
+		}
+	}
+	if _, err := githubApp.GenerateJWT(); err != nil {
+		fatal("Invalid GitHub App private key", "path", keyPath, "error", err)
 	}
 
-	doClient, err := digitalocean.NewClient(digitalocean.Config{
-		Token:           doToken,
-		Region:          region,
-		Size:            size,
-		CloudInitPath:   cloudInitPath,
-		SSHFingerprints: sshFingerprints,
-	})
+	runners, err := newProvider(envOrDefault("PROVIDER", "digitalocean"), logger)
+	if err != nil {
+		fatal("Failed to create runner provider", "error", err)
+	}
+
+	store, err := state.Open(statePath)
 	if err != nil {
-		log.Fatalf("Failed to create DO client: %v", err)
+		fatal("Failed to open state store", "error", err)
 	}
 
 	handler := webhook.NewHandler(webhook.Config{
-		WebhookSecret: webhookSecret,
-		GitHubApp:     githubApp,
-		DOClient:      doClient,
-		DOToken:       doToken,
-		RequiredLabel: requiredLabel,
+		WebhookSecret:  webhookSecret,
+		WebhookSecrets: webhookSecrets,
+		GitHubApp:      githubApp,
+		Installations:  installations,
+		Provider:       runners,
+		CallbackURL:    publicURL,
+		RequiredLabel:  requiredLabel,
+		Pools:          pools,
+		Store:          store,
+		DedupTTL:       dedupTTL,
+		Logger:         logger,
+
+		TrustedProxies:       trustedProxies,
+		HookAllowlist:        hookAllowlist,
+		HookAllowlistURL:     hookURL,
+		HookAllowlistRefresh: hookRefresh,
+
+		WarmMaxHourlyCost: warmMaxCost,
+		ReconcileInterval: reconcileInterval,
+		ReconcileGrace:    reconcileGrace,
+		ExcludeTag:        policy.ExcludeTag,
+		CallbackSecret:    selfDestructSecret,
+		// Optional; collector endpoint runners report boot phase spans to
+		RunnerTraceURL: os.Getenv("RUNNER_TRACE_URL"),
+		// Optional; mirror of actions/runner releases for air-gapped GHES
+		RunnerDownloadURL: os.Getenv("RUNNER_DOWNLOAD_URL"),
 	})
 
 	mux := http.NewServeMux()
 	mux.Handle("/webhook", handler)
+	mux.HandleFunc("/self-destruct", handler.ServeSelfDestruct)
+	mux.Handle("/metrics", metrics.Handler())
+
+	// Admin API: bearer ADMIN_TOKEN on the main listener, and/or client
+	// certificates on a separate mTLS listener
+	adminToken := []byte(os.Getenv("ADMIN_TOKEN"))
+	adminAPI := handler.AdminHandler(adminToken)
+	if len(adminToken) > 0 {
+		mux.Handle("/admin/", adminAPI)
+	}
+	adminSrv, err := newAdminServer(os.Getenv("ADMIN_LISTEN_ADDR"), adminAPI)
+	if err != nil {
+		fatal("Failed to configure admin listener", "error", err)
+	}
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
@@ -89,36 +258,151 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Provisioning workers drain the durable job queue
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	workersDone := make(chan struct{})
+	go func() {
+		handler.Run(workerCtx)
+		close(workersDone)
+	}()
+
+	// Pick up a rotated key without a restart; the old key stays as fallback
+	go githubApp.WatchPrivateKey(workerCtx, keyPath, reloadInterval)
+	if secretsPath != "" {
+		go handler.WatchWebhookSecrets(workerCtx, secretsPath, reloadInterval)
+	}
+
 	// Graceful shutdown: finish in-flight provisioning on SIGTERM/SIGINT
 	shutdownCh := make(chan os.Signal, 1)
 	signal.Notify(shutdownCh, syscall.SIGTERM, syscall.SIGINT)
 
 	go func() {
-		log.Printf("Webhook listener starting on %s", listenAddr)
+		slog.Info("Webhook listener starting", "addr", listenAddr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
+			fatal("Server failed", "error", err)
 		}
 	}()
 
+	if adminSrv != nil {
+		go func() {
+			slog.Info("Admin listener starting", "addr", adminSrv.Addr)
+			if err := adminSrv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				fatal("Admin server failed", "error", err)
+			}
+		}()
+	}
+
 	<-shutdownCh
-	log.Printf("Shutdown signal received, draining in-flight requests...")
+	slog.Info("Shutdown signal received, draining in-flight requests")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("Shutdown error: %v", err)
+		slog.Error("Shutdown error", "error", err)
+	}
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			slog.Error("Admin shutdown error", "error", err)
+		}
+	}
+
+	// Queued jobs stay in the state store and are resumed on next start
+	stopWorkers()
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+		slog.Warn("Timed out waiting for provisioning workers")
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// newAdminServer returns a TLS server for the admin API that requires
+// client certificates signed by ADMIN_CLIENT_CA, or nil if addr is empty.
+func newAdminServer(addr string, h http.Handler) (*http.Server, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(mustEnv("ADMIN_TLS_CERT"), mustEnv("ADMIN_TLS_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("load admin certificate: %w", err)
+	}
+	caPEM, err := os.ReadFile(mustEnv("ADMIN_CLIENT_CA"))
+	if err != nil {
+		return nil, fmt.Errorf("read admin client CA: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates in ADMIN_CLIENT_CA")
+	}
+	return &http.Server{
+		Addr:    addr,
+		Handler: h,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    clientCAs,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		},
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}, nil
+}
+
+// newProvider returns the backend that launches runners: DigitalOcean
+// droplets, or processes on this host for small jobs and local testing.
+func newProvider(kind string, logger *slog.Logger) (provider.Provider, error) {
+	switch kind {
+	case "digitalocean":
+		var sshFingerprints []string
+		if fp := os.Getenv("DO_SSH_FINGERPRINTS"); fp != "" {
+			sshFingerprints = strings.Split(fp, ",")
+		}
+		return digitalocean.NewClient(digitalocean.Config{
+			Token:           mustEnv("DIGITALOCEAN_TOKEN"),
+			Region:          envOrDefault("DO_REGION", "nyc3"),
+			Size:            envOrDefault("DO_SIZE", "s-4vcpu-8gb"),
+			CloudInitPath:   envOrDefault("CLOUD_INIT_PATH", "cloud-init/runner.yaml.tmpl"),
+			SSHFingerprints: sshFingerprints,
+			Logger:          logger,
+		})
+	case "local":
+		return local.New(local.Config{
+			Command: strings.Fields(mustEnv("LOCAL_RUNNER_COMMAND")),
+			Logger:  logger,
+		})
+	default:
+		return nil, fmt.Errorf("unknown PROVIDER %q (want digitalocean or local)", kind)
 	}
-	log.Printf("Server stopped")
 }
 
 func mustEnv(key string) string {
 	v := os.Getenv(key)
 	if v == "" {
-		log.Fatalf("Required environment variable %s is not set", key)
+		fatal("Required environment variable is not set", "name", key)
 	}
 	return v
 }
 
+// newLogger returns a JSON logger on stdout at the given level (debug, info,
+// warn or error; info if empty or unknown).
+func newLogger(level string) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: lvl}))
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
 func envOrDefault(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -0,0 +1,8 @@
+#!/bin/sh
+# Example LOCAL_RUNNER_COMMAND for PROVIDER=local: runs one ephemeral runner
+# in a throwaway container. The listener passes RUNNER_NAME, RUNNER_JITCONFIG,
+# RUNNER_IMAGE (the pool's "image") and friends in the environment.
+set -e
+exec docker run --rm --name "$RUNNER_NAME" \
+  "${RUNNER_IMAGE:-ghcr.io/actions/actions-runner:latest}" \
+  ./run.sh --jitconfig "$RUNNER_JITCONFIG"
@@ -0,0 +1,139 @@
+// Package cleanup holds the policy deciding which runner droplets and
+// runners the cleanup job removes.
+package cleanup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+)
+
+// Policy controls how long runner droplets may live and when offline runners
+// are deregistered. The zero value of a field means its default.
+type Policy struct {
+	// MaxAge is the age past which a runner droplet is deleted, unless a
+	// pool or tag entry allows longer.
+	MaxAge Duration `json:"max_age,omitzero"`
+	// Pools and Tags override MaxAge for droplets of a pool (tagged
+	// pool:<name>) or carrying a tag. If several match, the longest wins.
+	Pools map[string]Duration `json:"pools,omitempty"`
+	Tags  map[string]Duration `json:"tags,omitempty"`
+	// BootGrace protects droplets younger than this from any age limit, so a
+	// short limit cannot kill droplets that are still booting.
+	BootGrace Duration `json:"boot_grace,omitzero"`
+	// ExcludeTag marks droplets under debugging; cleanup never touches them
+	// or their runners.
+	ExcludeTag string `json:"exclude_tag,omitempty"`
+	// RunnerOfflineMin is how long a runner must have been seen offline
+	// before it is deregistered.
+	RunnerOfflineMin Duration `json:"runner_offline_min,omitzero"`
+}
+
+// Defaults used for fields a policy leaves empty.
+const (
+	DefaultMaxAge     = 60 * time.Minute
+	DefaultBootGrace  = 10 * time.Minute
+	DefaultExcludeTag = "debug"
+)
+
+// Default returns the policy used without a policy file.
+func Default() *Policy {
+	p := &Policy{}
+	p.setDefaults()
+	return p
+}
+
+// Load reads a policy from a JSON file such as
+// {"max_age": "60m", "pools": {"chef": "3h"}, "exclude_tag": "debug"}.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read cleanup policy: %w", err)
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("decode cleanup policy: %w", err)
+	}
+	p.setDefaults()
+	return p, nil
+}
+
+func (p *Policy) setDefaults() {
+	if p.MaxAge <= 0 {
+		p.MaxAge = Duration(DefaultMaxAge)
+	}
+	if p.BootGrace <= 0 {
+		p.BootGrace = Duration(DefaultBootGrace)
+	}
+	if p.ExcludeTag == "" {
+		p.ExcludeTag = DefaultExcludeTag
+	}
+}
+
+// MaxAgeFor returns the age limit of a droplet with the given tags. A TTL tag
+// shortens the limit but never extends it, since any workflow can ask for a
+// long TTL through a job label. The limit is never shorter than the boot
+// grace period.
+func (p *Policy) MaxAgeFor(tags []string) time.Duration {
+	limit := p.MaxAge
+	matched := false
+	for _, t := range tags {
+		d, ok := p.Tags[t]
+		if !ok {
+			if name, isPool := strings.CutPrefix(t, "pool:"); isPool {
+				d, ok = p.Pools[name]
+			}
+		}
+		if ok && (!matched || d > limit) {
+			limit = d
+			matched = true
+		}
+	}
+	if ttl, ok := provider.TTLFromTags(tags); ok && ttl < limit.Std() {
+		limit = Duration(ttl)
+	}
+	return max(limit.Std(), p.BootGrace.Std())
+}
+
+// Excluded reports whether a droplet with the given tags is exempt from
+// cleanup.
+func (p *Policy) Excluded(tags []string) bool {
+	for _, t := range tags {
+		if t == p.ExcludeTag {
+			return true
+		}
+	}
+	return false
+}
+
+// Duration is a time.Duration written as a string such as "90m" in JSON.
+type Duration time.Duration
+
+// Std returns d as a time.Duration.
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"90m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if v < 0 {
+		return fmt.Errorf("duration %q must not be negative", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
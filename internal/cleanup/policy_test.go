@@ -0,0 +1,81 @@
+package cleanup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	p := Default()
+	if got := p.MaxAgeFor(nil); got != DefaultMaxAge {
+		t.Errorf("MaxAgeFor() = %v, want %v", got, DefaultMaxAge)
+	}
+	if !p.Excluded([]string{"github-runner", "debug"}) {
+		t.Error("expected droplets tagged debug to be excluded")
+	}
+	if p.RunnerOfflineMin != 0 {
+		t.Errorf("expected offline runners removed right away by default, got %v", p.RunnerOfflineMin.Std())
+	}
+}
+
+func TestMaxAgeFor(t *testing.T) {
+	p := &Policy{
+		MaxAge:    Duration(time.Hour),
+		Pools:     map[string]Duration{"chef": Duration(3 * time.Hour), "small": Duration(20 * time.Minute)},
+		Tags:      map[string]Duration{"chef-integration": Duration(2 * time.Hour)},
+		BootGrace: Duration(30 * time.Minute),
+	}
+
+	tests := []struct {
+		tags []string
+		want time.Duration
+	}{
+		{[]string{"github-runner"}, time.Hour},
+		{[]string{"github-runner", "pool:chef"}, 3 * time.Hour},
+		{[]string{"chef-integration"}, 2 * time.Hour},
+		{[]string{"chef-integration", "pool:chef"}, 3 * time.Hour}, // longest wins
+		{[]string{"pool:small"}, 30 * time.Minute},                 // never below boot grace
+		{[]string{"pool:chef", "ttl:150m"}, 150 * time.Minute},     // a TTL tag shortens the limit
+		{[]string{"github-runner", "ttl:1440m"}, time.Hour},        // but never extends it
+		{[]string{"pool:chef", "ttl:5m"}, 30 * time.Minute},        // nor goes below boot grace
+	}
+	for _, tt := range tests {
+		if got := p.MaxAgeFor(tt.tags); got != tt.want {
+			t.Errorf("MaxAgeFor(%v) = %v, want %v", tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	data := `{"max_age": "90m", "pools": {"chef": "3h"}, "exclude_tag": "hold", "runner_offline_min": "15m"}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if p.MaxAge.Std() != 90*time.Minute || p.RunnerOfflineMin.Std() != 15*time.Minute {
+		t.Errorf("unexpected durations: %+v", p)
+	}
+	if p.BootGrace.Std() != DefaultBootGrace {
+		t.Errorf("expected default boot grace, got %v", p.BootGrace.Std())
+	}
+	if p.Excluded([]string{"debug"}) || !p.Excluded([]string{"hold"}) {
+		t.Error("expected only the configured exclude tag to apply")
+	}
+}
+
+func TestLoadRejectsBadDuration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(`{"max_age": 60}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("expected error for a numeric duration")
+	}
+}
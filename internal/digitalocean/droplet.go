@@ -3,16 +3,27 @@ package digitalocean
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/digitalocean/godo"
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+	"github.com/thomasvincent/github-runners-infra/internal/tracing"
 	"golang.org/x/oauth2"
 )
 
-// Client wraps the DigitalOcean API client.
+// WarmTag marks idle pre-booted runners kept by the warm pool.
+const WarmTag = provider.WarmTag
+
+var _ provider.Provider = (*Client)(nil)
+
+// Client wraps the DigitalOcean API client and implements provider.Provider.
 type Client struct {
 	client          *godo.Client
 	cloudInitTmpl   *template.Template
@@ -20,6 +31,10 @@ type Client struct {
 	size            string
 	image           string
 	sshFingerprints []string
+	log             *slog.Logger
+
+	pricesMu sync.Mutex
+	prices   map[string]float64 // size slug -> hourly USD
 }
 
 // Config holds DigitalOcean client configuration.
@@ -29,18 +44,27 @@ type Config struct {
 	Size            string
 	Image           string
 	SSHFingerprints []string
-	CloudInitPath   string
+	CloudInitPath   string       // optional for clients that never create droplets
+	Logger          *slog.Logger // defaults to slog.Default()
 }
 
 // NewClient creates a new DigitalOcean API client.
 func NewClient(cfg Config) (*Client, error) {
+	// Each API call is a client span under the caller's context
+	base := &http.Client{Transport: tracing.Transport("digitalocean", nil)}
 	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: cfg.Token})
-	tc := oauth2.NewClient(context.Background(), ts)
+	tc := oauth2.NewClient(context.WithValue(context.Background(), oauth2.HTTPClient, base), ts)
 	client := godo.NewClient(tc)
 
-	tmpl, err := template.ParseFiles(cfg.CloudInitPath)
-	if err != nil {
-		return nil, fmt.Errorf("parse cloud-init template: %w", err)
+	// Without a template the client can only list and delete droplets, or
+	// create them from a pool's own template.
+	var tmpl *template.Template
+	if cfg.CloudInitPath != "" {
+		var err error
+		tmpl, err = template.ParseFiles(cfg.CloudInitPath)
+		if err != nil {
+			return nil, fmt.Errorf("parse cloud-init template: %w", err)
+		}
 	}
 
 	region := cfg.Region
@@ -55,6 +79,10 @@ func NewClient(cfg Config) (*Client, error) {
 	if image == "" {
 		image = "ubuntu-24-04-x64"
 	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
 
 	return &Client{
 		client:          client,
@@ -63,25 +91,22 @@ func NewClient(cfg Config) (*Client, error) {
 		size:            size,
 		image:           image,
 		sshFingerprints: cfg.SSHFingerprints,
+		log:             logger,
 	}, nil
 }
 
-// RunnerParams holds parameters for cloud-init template rendering.
-type RunnerParams struct {
-	RunnerName    string
-	RunnerToken   string // passed directly; no SSM required on the droplet
-	RunnerLabels  string
-	RunnerOrg     string
-	RunnerRepo    string
-	DOToken       string
-	RunnerVersion string
-}
-
 // CreateRunner spins up an ephemeral runner droplet.
-func (c *Client) CreateRunner(ctx context.Context, params RunnerParams) (*godo.Droplet, error) {
+func (c *Client) CreateRunner(ctx context.Context, spec provider.Spec, params provider.RunnerParams) (provider.Instance, error) {
+	tmpl := c.cloudInitTmpl
+	if spec.CloudInit != nil {
+		tmpl = spec.CloudInit
+	}
+	if tmpl == nil {
+		return provider.Instance{}, errors.New("no cloud-init template configured")
+	}
 	var userData bytes.Buffer
-	if err := c.cloudInitTmpl.Execute(&userData, params); err != nil {
-		return nil, fmt.Errorf("render cloud-init: %w", err)
+	if err := tmpl.Execute(&userData, params); err != nil {
+		return provider.Instance{}, fmt.Errorf("render cloud-init: %w", err)
 	}
 
 	var keys []godo.DropletCreateSSHKey
@@ -91,23 +116,27 @@ func (c *Client) CreateRunner(ctx context.Context, params RunnerParams) (*godo.D
 
 	createReq := &godo.DropletCreateRequest{
 		Name:   params.RunnerName,
-		Region: c.region,
-		Size:   c.size,
+		Region: orDefault(spec.Region, c.region),
+		Size:   orDefault(spec.Size, c.size),
 		Image: godo.DropletCreateImage{
-			Slug: c.image,
+			Slug: orDefault(spec.Image, c.image),
 		},
 		UserData: userData.String(),
 		SSHKeys:  keys,
-		Tags:     []string{"github-runner", "ephemeral"},
+		Tags:     append([]string{"github-runner", "ephemeral"}, spec.Tags...),
+	}
+	// Cleanup reads the TTL back from the tag
+	if spec.TTL > 0 {
+		createReq.Tags = append(createReq.Tags, provider.TTLTag(spec.TTL))
 	}
 
 	droplet, _, err := c.client.Droplets.Create(ctx, createReq)
 	if err != nil {
-		return nil, fmt.Errorf("create droplet: %w", err)
+		return provider.Instance{}, fmt.Errorf("create droplet: %w", err)
 	}
 
-	log.Printf("Created runner droplet %s (ID: %d)", params.RunnerName, droplet.ID)
-	return droplet, nil
+	c.log.Info("Created runner droplet", "runner_name", params.RunnerName, "droplet_id", droplet.ID)
+	return instance(*droplet), nil
 }
 
 // DeleteDroplet removes a droplet by ID.
@@ -116,16 +145,65 @@ func (c *Client) DeleteDroplet(ctx context.Context, id int) error {
 	return err
 }
 
+// DeleteRunner removes a runner droplet by ID.
+func (c *Client) DeleteRunner(ctx context.Context, id int) error {
+	return c.DeleteDroplet(ctx, id)
+}
+
+// ListRunners returns all droplets carrying tag.
+func (c *Client) ListRunners(ctx context.Context, tag string) ([]provider.Instance, error) {
+	droplets, err := c.ListDropletsByTag(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]provider.Instance, len(droplets))
+	for i, d := range droplets {
+		instances[i] = instance(d)
+	}
+	return instances, nil
+}
+
+// DescribeRunner returns a single droplet, or provider.ErrNotFound.
+func (c *Client) DescribeRunner(ctx context.Context, id int) (provider.Instance, error) {
+	droplet, resp, err := c.client.Droplets.Get(ctx, id)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return provider.Instance{}, fmt.Errorf("droplet %d: %w", id, provider.ErrNotFound)
+	}
+	if err != nil {
+		return provider.Instance{}, fmt.Errorf("get droplet %d: %w", id, err)
+	}
+	return instance(*droplet), nil
+}
+
+func instance(d godo.Droplet) provider.Instance {
+	created, _ := time.Parse(time.RFC3339, d.Created)
+	inst := provider.Instance{
+		ID:      d.ID,
+		Name:    d.Name,
+		Tags:    d.Tags,
+		Created: created,
+	}
+	if d.Size != nil {
+		inst.PriceHourly = d.Size.PriceHourly
+	}
+	return inst
+}
+
 // ListRunnerDroplets returns all droplets tagged as github-runner.
-// Paginates through all pages to ensure no droplets are missed.
 func (c *Client) ListRunnerDroplets(ctx context.Context) ([]godo.Droplet, error) {
+	return c.ListDropletsByTag(ctx, "github-runner")
+}
+
+// ListDropletsByTag returns all droplets carrying tag.
+// Paginates through all pages to ensure no droplets are missed.
+func (c *Client) ListDropletsByTag(ctx context.Context, tag string) ([]godo.Droplet, error) {
 	var allDroplets []godo.Droplet
 	opt := &godo.ListOptions{PerPage: 200}
 
 	for {
-		droplets, resp, err := c.client.Droplets.ListByTag(ctx, "github-runner", opt)
+		droplets, resp, err := c.client.Droplets.ListByTag(ctx, tag, opt)
 		if err != nil {
-			return nil, fmt.Errorf("list runner droplets: %w", err)
+			return nil, fmt.Errorf("list %s droplets: %w", tag, err)
 		}
 		allDroplets = append(allDroplets, droplets...)
 
@@ -142,27 +220,83 @@ func (c *Client) ListRunnerDroplets(ctx context.Context) ([]godo.Droplet, error)
 	return allDroplets, nil
 }
 
-// CleanupOldDroplets deletes runner droplets older than maxAge.
-func (c *Client) CleanupOldDroplets(ctx context.Context, maxAge time.Duration) (int, error) {
-	droplets, err := c.ListRunnerDroplets(ctx)
-	if err != nil {
-		return 0, err
+// UntagRunner removes tag from a droplet.
+func (c *Client) UntagRunner(ctx context.Context, id int, tag string) error {
+	_, err := c.client.Tags.UntagResources(ctx, tag, &godo.UntagResourcesRequest{
+		Resources: []godo.Resource{{ID: strconv.Itoa(id), Type: godo.DropletResourceType}},
+	})
+	return err
+}
+
+// PriceHourly returns the hourly USD price of a droplet size, or of the
+// client's default size if slug is empty. Prices are fetched once and cached.
+func (c *Client) PriceHourly(ctx context.Context, slug string) (float64, error) {
+	slug = orDefault(slug, c.size)
+
+	c.pricesMu.Lock()
+	defer c.pricesMu.Unlock()
+
+	if c.prices == nil {
+		prices := make(map[string]float64)
+		opt := &godo.ListOptions{PerPage: 200}
+		for {
+			sizes, resp, err := c.client.Sizes.List(ctx, opt)
+			if err != nil {
+				return 0, fmt.Errorf("list sizes: %w", err)
+			}
+			for _, sz := range sizes {
+				prices[sz.Slug] = sz.PriceHourly
+			}
+			if resp.Links == nil || resp.Links.IsLastPage() {
+				break
+			}
+			page, err := resp.Links.CurrentPage()
+			if err != nil {
+				break
+			}
+			opt.Page = page + 1
+		}
+		c.prices = prices
 	}
 
-	cutoff := time.Now().Add(-maxAge)
-	deleted := 0
+	price, ok := c.prices[slug]
+	if !ok {
+		return 0, fmt.Errorf("unknown droplet size %q", slug)
+	}
+	return price, nil
+}
 
+// StaleDroplets returns the droplets created more than maxAge(tags) before
+// now. Idle warm runners are recycled by the warm pool manager, so cleanup
+// only deletes them once they are also older than provider.WarmMaxAge, e.g.
+// after their pool was removed.
+func StaleDroplets(droplets []godo.Droplet, maxAge func(tags []string) time.Duration, now time.Time) []godo.Droplet {
+	var stale []godo.Droplet
 	for _, d := range droplets {
+		limit := maxAge(d.Tags)
+		if hasTag(d.Tags, WarmTag) {
+			limit = max(limit, provider.WarmMaxAge)
+		}
 		created, _ := time.Parse(time.RFC3339, d.Created)
-		if created.Before(cutoff) {
-			log.Printf("Deleting stale runner droplet %s (ID: %d, created: %s)", d.Name, d.ID, d.Created)
-			if err := c.DeleteDroplet(ctx, d.ID); err != nil {
-				log.Printf("Failed to delete droplet %d: %v", d.ID, err)
-				continue
-			}
-			deleted++
+		if created.Before(now.Add(-limit)) {
+			stale = append(stale, d)
 		}
 	}
+	return stale
+}
+
+func orDefault(v, fallback string) string {
+	if v != "" {
+		return v
+	}
+	return fallback
+}
 
-	return deleted, nil
+func hasTag(tags []string, want string) bool {
+	for _, t := range tags {
+		if t == want {
+			return true
+		}
+	}
+	return false
 }
@@ -2,19 +2,24 @@ package digitalocean
 
 import (
 	"bytes"
+	"context"
+	"strings"
 	"testing"
 	"text/template"
 	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
 )
 
 func TestRunnerParams_Fields(t *testing.T) {
-	p := RunnerParams{
+	p := provider.RunnerParams{
 		RunnerName:    "eph-test-123-456",
-		RunnerToken:   "AABCDEF",
-		RunnerLabels:  "self-hosted,linux",
+		JITConfig:     "eyJydW5uZXIiOiJ0ZXN0In0=",
 		RunnerOrg:     "myorg",
 		RunnerRepo:    "myorg/myrepo",
-		DOToken:       "do-token",
+		CallbackURL:   "https://runners.example.com/self-destruct?runner=eph-test-123-456",
+		CallbackToken: "cb-token",
 		RunnerVersion: "2.331.0",
 	}
 
@@ -28,13 +33,13 @@ func TestRunnerParams_Fields(t *testing.T) {
 
 func TestCloudInitTemplateRendering(t *testing.T) {
 	tmpl := template.Must(template.New("test").Parse(
-		"name={{.RunnerName}} repo={{.RunnerRepo}} token={{.RunnerToken}} version={{.RunnerVersion}}"))
+		"name={{.RunnerName}} repo={{.RunnerRepo}} jit={{.JITConfig}} version={{.RunnerVersion}}"))
 
 	client := &Client{cloudInitTmpl: tmpl}
 
-	params := RunnerParams{
+	params := provider.RunnerParams{
 		RunnerName:    "eph-test-1-1234",
-		RunnerToken:   "ABCTOKEN",
+		JITConfig:     "ABCJIT",
 		RunnerRepo:    "org/repo",
 		RunnerVersion: "2.331.0",
 	}
@@ -46,12 +51,39 @@ func TestCloudInitTemplateRendering(t *testing.T) {
 	}
 
 	result := buf.String()
-	expected := "name=eph-test-1-1234 repo=org/repo token=ABCTOKEN version=2.331.0"
+	expected := "name=eph-test-1-1234 repo=org/repo jit=ABCJIT version=2.331.0"
 	if result != expected {
 		t.Errorf("template rendered %q, want %q", result, expected)
 	}
 }
 
+func TestCloudInitTemplatePassesTraceContext(t *testing.T) {
+	tmpl := template.Must(template.ParseFiles("../../cloud-init/runner.yaml.tmpl"))
+
+	params := provider.RunnerParams{
+		RunnerName:    "eph-test-1-1234",
+		JITConfig:     "ABCJIT",
+		RunnerVersion: "2.331.0",
+		TraceParent:   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		TraceURL:      "http://collector.internal:4318",
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		t.Fatalf("template execution failed: %v", err)
+	}
+	for _, want := range []string{
+		`TRACEPARENT="00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"`,
+		`TRACE_URL="http://collector.internal:4318"`,
+		"sleep 5400",
+		"report-phase install-runner",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("rendered cloud-init missing %q", want)
+		}
+	}
+}
+
 func TestDropletAgeFiltering(t *testing.T) {
 	maxAge := 60 * time.Minute
 	cutoff := time.Now().Add(-maxAge)
@@ -78,6 +110,60 @@ func TestDropletAgeFiltering(t *testing.T) {
 	}
 }
 
+func TestStaleDroplets(t *testing.T) {
+	now := time.Now()
+	created := func(age time.Duration) string { return now.Add(-age).Format(time.RFC3339) }
+	droplets := []godo.Droplet{
+		{ID: 1, Created: created(90 * time.Minute)},
+		{ID: 2, Created: created(30 * time.Minute)},
+		{ID: 3, Created: created(90 * time.Minute), Tags: []string{"github-runner", WarmTag}},
+	}
+
+	hour := func([]string) time.Duration { return 60 * time.Minute }
+	stale := StaleDroplets(droplets, hour, now)
+	if len(stale) != 1 || stale[0].ID != 1 {
+		t.Errorf("expected only droplet 1 stale, got %v", stale)
+	}
+
+	long := func(tags []string) time.Duration {
+		if len(tags) > 0 && tags[0] == "chef" {
+			return 2 * time.Hour
+		}
+		return 60 * time.Minute
+	}
+	droplets[0].Tags = []string{"chef"}
+	if stale := StaleDroplets(droplets, long, now); len(stale) != 0 {
+		t.Errorf("expected per-tag limit to keep droplet 1, got %v", stale)
+	}
+}
+
+func TestStaleDropletsCapsWarmRunners(t *testing.T) {
+	now := time.Now()
+	created := func(age time.Duration) string { return now.Add(-age).Format(time.RFC3339) }
+	tags := []string{"github-runner", WarmTag}
+	droplets := []godo.Droplet{
+		{ID: 1, Created: created(90 * time.Minute), Tags: tags},
+		{ID: 2, Created: created(provider.WarmMaxAge + time.Minute), Tags: tags},
+	}
+
+	hour := func([]string) time.Duration { return 60 * time.Minute }
+	stale := StaleDroplets(droplets, hour, now)
+	if len(stale) != 1 || stale[0].ID != 2 {
+		t.Errorf("expected only the warm droplet past the hard cap stale, got %v", stale)
+	}
+}
+
+func TestNewClientWithoutTemplate(t *testing.T) {
+	c, err := NewClient(Config{Token: "test"})
+	if err != nil {
+		t.Fatalf("read/delete-only client should not need a template: %v", err)
+	}
+	_, err = c.CreateRunner(context.Background(), provider.Spec{}, provider.RunnerParams{RunnerName: "eph-x"})
+	if err == nil || !strings.Contains(err.Error(), "no cloud-init template") {
+		t.Errorf("expected missing template error, got %v", err)
+	}
+}
+
 func TestDefaultConfig(t *testing.T) {
 	// Verify that empty config fields get defaults
 	cfg := Config{
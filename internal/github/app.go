@@ -1,11 +1,15 @@
 package github
 
 import (
-	"crypto/x509"
-	"encoding/pem"
+	"context"
+	"crypto/rsa"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,27 +29,108 @@ var HTTPClient = &http.Client{
 	},
 }
 
-// App represents a GitHub App for authentication.
+// Default endpoints of github.com. GitHub Enterprise Server serves the API
+// at https://HOST/api/v3.
+const (
+	DefaultAPIURL = "https://api.github.com"
+	DefaultWebURL = "https://github.com"
+)
+
+// App represents a GitHub App for authentication. Installation API calls act
+// for the installation carried by their context (see WithInstallation), or
+// InstallationID when it carries none.
 type App struct {
 	AppID          int64
-	InstallationID int64
-	PrivateKey     []byte
+	InstallationID int64  // default installation, 0 if calls always name one
+	PrivateKey     []byte // PEM, PKCS#1 or PKCS#8, optionally encrypted with KeyPassphrase
+	PreviousKey    []byte // optional second key, tried when GitHub rejects PrivateKey during a rotation
+	KeyPassphrase  []byte
+	APIURL         string       // REST API root, defaults to DefaultAPIURL
+	WebURL         string       // web root runners and users see, defaults to DefaultWebURL
+	Logger         *slog.Logger // defaults to slog.Default()
+
+	keyMu sync.Mutex
+	keys  []*rsa.PrivateKey // parsed signing keys, current first
+
+	tokenMu    sync.Mutex
+	tokens     map[int64]installationToken // installation ID -> cached token
+	tokenLocks map[int64]*sync.Mutex       // installation ID -> held while minting its token
+	owners     map[string]int64            // account login -> installation ID
+
+	groupsMu sync.Mutex
+	groups   map[string]int64 // "org/name" -> runner group ID
+
+	rateMu           sync.Mutex
+	rateLimitedUntil map[int64]time.Time // installation ID (0 for the app) -> reset of an exhausted rate limit
 
-	tokenMu      sync.Mutex
-	cachedToken  string
-	tokenExpires time.Time
+	retry retryPolicy // zero means defaultRetry
 }
 
-// GenerateJWT creates a short-lived JWT for GitHub App authentication.
-func (a *App) GenerateJWT() (string, error) {
-	block, _ := pem.Decode(a.PrivateKey)
-	if block == nil {
-		return "", fmt.Errorf("failed to decode PEM block")
+type installationToken struct {
+	token   string
+	expires time.Time
+}
+
+type installationKey struct{}
+
+// WithInstallation returns a context whose API calls act for installation id.
+func WithInstallation(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, installationKey{}, id)
+}
+
+// InstallationFrom returns the installation set by WithInstallation.
+func InstallationFrom(ctx context.Context) (int64, bool) {
+	id, ok := ctx.Value(installationKey{}).(int64)
+	return id, ok && id != 0
+}
+
+// installationID returns the installation ctx acts for.
+func (a *App) installationID(ctx context.Context) (int64, error) {
+	if id, ok := InstallationFrom(ctx); ok {
+		return id, nil
+	}
+	if a.InstallationID == 0 {
+		return 0, errors.New("no GitHub App installation in context")
+	}
+	return a.InstallationID, nil
+}
+
+func (a *App) log() *slog.Logger {
+	if a.Logger == nil {
+		return slog.Default()
+	}
+	return a.Logger
+}
+
+// apiURL returns the API URL of path, formatted with args.
+func (a *App) apiURL(path string, args ...any) string {
+	base := DefaultAPIURL
+	if a.APIURL != "" {
+		base = strings.TrimSuffix(a.APIURL, "/")
+	}
+	return base + "/" + fmt.Sprintf(path, args...)
+}
+
+// ServerURL returns the web URL of the GitHub instance the app belongs to.
+func (a *App) ServerURL() string {
+	if a.WebURL == "" {
+		return DefaultWebURL
 	}
+	return strings.TrimSuffix(a.WebURL, "/")
+}
+
+// GenerateJWT creates a short-lived JWT for GitHub App authentication,
+// signed with the current private key.
+func (a *App) GenerateJWT() (string, error) {
+	token, _, err := a.generateJWT()
+	return token, err
+}
 
-	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+// generateJWT returns a JWT and the key it was signed with.
+func (a *App) generateJWT() (string, *rsa.PrivateKey, error) {
+	key, err := a.signingKey()
 	if err != nil {
-		return "", fmt.Errorf("parse private key: %w", err)
+		return "", nil, fmt.Errorf("parse private key: %w", err)
 	}
 
 	now := time.Now()
@@ -55,35 +140,43 @@ func (a *App) GenerateJWT() (string, error) {
 		Issuer:    fmt.Sprintf("%d", a.AppID),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(key)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	return token, key, err
 }
 
-// InstallationToken retrieves an installation access token, returning a
-// cached token if it is still valid (with a 5-minute safety margin).
-func (a *App) InstallationToken() (string, error) {
-	a.tokenMu.Lock()
-	defer a.tokenMu.Unlock()
-
-	// Return cached token if still valid with 5 min buffer
-	if a.cachedToken != "" && time.Now().Before(a.tokenExpires.Add(-5*time.Minute)) {
-		return a.cachedToken, nil
+// InstallationToken retrieves an access token for the installation ctx acts
+// for, returning a cached token if it is still valid (with a 5-minute safety
+// margin). Tokens are cached per installation, and concurrent callers of one
+// installation share a single request for a new token.
+func (a *App) InstallationToken(ctx context.Context) (string, error) {
+	id, err := a.installationID(ctx)
+	if err != nil {
+		return "", err
+	}
+	if token, ok := a.cachedToken(id); ok {
+		return token, nil
 	}
 
-	jwtToken, err := a.GenerateJWT()
-	if err != nil {
-		return "", fmt.Errorf("generate JWT: %w", err)
+	// Only callers of the same installation wait for the request
+	a.tokenMu.Lock()
+	mu, ok := a.tokenLocks[id]
+	if !ok {
+		if a.tokenLocks == nil {
+			a.tokenLocks = make(map[int64]*sync.Mutex)
+		}
+		mu = &sync.Mutex{}
+		a.tokenLocks[id] = mu
 	}
+	a.tokenMu.Unlock()
 
-	url := fmt.Sprintf("https://api.github.com/app/installations/%d/access_tokens", a.InstallationID)
-	req, err := http.NewRequest(http.MethodPost, url, nil)
-	if err != nil {
-		return "", err
+	mu.Lock()
+	defer mu.Unlock()
+	if token, ok := a.cachedToken(id); ok {
+		return token, nil
 	}
-	req.Header.Set("Authorization", "Bearer "+jwtToken)
-	req.Header.Set("Accept", "application/vnd.github+json")
 
-	resp, err := HTTPClient.Do(req)
+	url := a.apiURL("app/installations/%d/access_tokens", id)
+	resp, err := a.do(ctx, http.MethodPost, url, nil, authApp)
 	if err != nil {
 		return "", fmt.Errorf("request installation token: %w", err)
 	}
@@ -101,8 +194,161 @@ func (a *App) InstallationToken() (string, error) {
 		return "", err
 	}
 
-	a.cachedToken = result.Token
-	a.tokenExpires = result.ExpiresAt
+	a.tokenMu.Lock()
+	defer a.tokenMu.Unlock()
+	if a.tokens == nil {
+		a.tokens = make(map[int64]installationToken)
+	}
+	a.tokens[id] = installationToken{token: result.Token, expires: result.ExpiresAt}
 
 	return result.Token, nil
 }
+
+// cachedToken returns the cached token of installation id if it is still
+// valid for 5 minutes.
+func (a *App) cachedToken(id int64) (string, bool) {
+	a.tokenMu.Lock()
+	defer a.tokenMu.Unlock()
+	t, ok := a.tokens[id]
+	if !ok || !time.Now().Before(t.expires.Add(-5*time.Minute)) {
+		return "", false
+	}
+	return t.token, true
+}
+
+// invalidateToken drops the cached token of installation id so the next
+// request mints a new one.
+func (a *App) invalidateToken(id int64) {
+	a.tokenMu.Lock()
+	defer a.tokenMu.Unlock()
+	delete(a.tokens, id)
+}
+
+// Installation describes the account a GitHub App installation belongs to.
+type Installation struct {
+	ID      int64 `json:"id"`
+	Account struct {
+		Login string `json:"login"`
+		Type  string `json:"type"` // "Organization" or "User"
+	} `json:"account"`
+}
+
+// Installation returns the account of the installation ctx acts for.
+func (a *App) Installation(ctx context.Context) (*Installation, error) {
+	id, err := a.installationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	url := a.apiURL("app/installations/%d", id)
+	resp, err := a.do(ctx, http.MethodGet, url, nil, authApp)
+	if err != nil {
+		return nil, fmt.Errorf("request installation: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d requesting installation", resp.StatusCode)
+	}
+
+	var inst Installation
+	if err := decodeJSON(resp.Body, &inst); err != nil {
+		return nil, err
+	}
+	return &inst, nil
+}
+
+// ParseInstallationIDs parses a comma-separated list of installation IDs.
+func ParseInstallationIDs(s string) ([]int64, error) {
+	var ids []int64
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid installation ID %q", f)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ListInstallations returns every installation of the app.
+func (a *App) ListInstallations(ctx context.Context) ([]Installation, error) {
+	var all []Installation
+	for page := 1; ; page++ {
+		url := a.apiURL("app/installations?per_page=100&page=%d", page)
+		resp, err := a.do(ctx, http.MethodGet, url, nil, authApp)
+		if err != nil {
+			return nil, fmt.Errorf("list installations: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("unexpected status %d listing installations", resp.StatusCode)
+		}
+
+		var result []Installation
+		err = decodeJSON(resp.Body, &result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, result...)
+		if len(result) < 100 {
+			return all, nil
+		}
+	}
+}
+
+// AccountInstallation returns the ID of the installation on the org or user
+// account login. Results are cached for the life of the App.
+func (a *App) AccountInstallation(ctx context.Context, login string) (int64, error) {
+	key := strings.ToLower(login)
+	a.tokenMu.Lock()
+	id, ok := a.owners[key]
+	a.tokenMu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	url := a.apiURL("users/%s/installation", login)
+	resp, err := a.do(ctx, http.MethodGet, url, nil, authApp)
+	if err != nil {
+		return 0, fmt.Errorf("request installation of %s: %w", login, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d requesting installation of %s", resp.StatusCode, login)
+	}
+
+	var inst Installation
+	if err := decodeJSON(resp.Body, &inst); err != nil {
+		return 0, err
+	}
+
+	a.tokenMu.Lock()
+	if a.owners == nil {
+		a.owners = make(map[string]int64)
+	}
+	a.owners[key] = inst.ID
+	a.tokenMu.Unlock()
+	return inst.ID, nil
+}
+
+// RedeliverHook asks GitHub to send the app webhook delivery with the given
+// ID again, as shown under the app's Advanced settings.
+func (a *App) RedeliverHook(ctx context.Context, deliveryID int64) error {
+	url := a.apiURL("app/hook/deliveries/%d/attempts", deliveryID)
+	resp, err := a.do(ctx, http.MethodPost, url, nil, authApp)
+	if err != nil {
+		return fmt.Errorf("redeliver hook delivery: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("unexpected status %d redelivering hook delivery %d", resp.StatusCode, deliveryID)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package github
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestApp returns an App with a fresh key talking to apiURL.
+func newTestApp(t *testing.T, apiURL string) *App {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return &App{AppID: 1, InstallationID: 42, PrivateKey: pemKey, APIURL: apiURL}
+}
+
+func TestAPIURL(t *testing.T) {
+	app := &App{}
+	if got := app.apiURL("repos/%s/%s/actions/runners", "org", "repo"); got != "https://api.github.com/repos/org/repo/actions/runners" {
+		t.Errorf("default apiURL = %q", got)
+	}
+	app.APIURL = "https://ghes.example.com/api/v3/"
+	if got := app.apiURL("installation/repositories"); got != "https://ghes.example.com/api/v3/installation/repositories" {
+		t.Errorf("GHES apiURL = %q", got)
+	}
+}
+
+func TestServerURL(t *testing.T) {
+	if got := (&App{}).ServerURL(); got != DefaultWebURL {
+		t.Errorf("ServerURL() = %q, want %q", got, DefaultWebURL)
+	}
+	if got := (&App{WebURL: "https://ghes.example.com/"}).ServerURL(); got != "https://ghes.example.com" {
+		t.Errorf("ServerURL() = %q", got)
+	}
+}
+
+func TestInstallationTokenCachedPerInstallation(t *testing.T) {
+	var minted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/app/installations/"), "/access_tokens")
+		minted = append(minted, id)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{"token": "token-" + id, "expires_at": time.Now().Add(time.Hour)})
+	}))
+	defer server.Close()
+	app := newTestApp(t, server.URL)
+
+	for _, id := range []int64{7, 8, 7} {
+		token, err := app.InstallationToken(WithInstallation(context.Background(), id))
+		if err != nil {
+			t.Fatalf("InstallationToken(%d): %v", id, err)
+		}
+		if want := fmt.Sprintf("token-%d", id); token != want {
+			t.Errorf("installation %d got token %q, want %q", id, token, want)
+		}
+	}
+	if token, _ := app.InstallationToken(context.Background()); token != "token-42" {
+		t.Errorf("expected default installation without context, got %q", token)
+	}
+	if !slices.Equal(minted, []string{"7", "8", "42"}) {
+		t.Errorf("expected one token minted per installation, got %v", minted)
+	}
+}
+
+func TestInstallationTokenLocksPerInstallation(t *testing.T) {
+	release := make(chan struct{})
+	var mints atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/app/installations/"), "/access_tokens")
+		if id == "7" {
+			<-release // installation 7 is slow to answer
+		}
+		mints.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{"token": "token-" + id, "expires_at": time.Now().Add(time.Hour)})
+	}))
+	defer server.Close()
+	app := newTestApp(t, server.URL)
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 3)
+	for i := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokens[i], _ = app.InstallationToken(WithInstallation(context.Background(), 7))
+		}()
+	}
+
+	done := make(chan string)
+	go func() {
+		token, _ := app.InstallationToken(WithInstallation(context.Background(), 8))
+		done <- token
+	}()
+	select {
+	case token := <-done:
+		if token != "token-8" {
+			t.Errorf("installation 8 got token %q", token)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("installation 8 waited on installation 7's token request")
+	}
+
+	close(release)
+	wg.Wait()
+	for _, token := range tokens {
+		if token != "token-7" {
+			t.Errorf("installation 7 got token %q", token)
+		}
+	}
+	if mints.Load() != 2 {
+		t.Errorf("expected one token minted per installation, got %d mints", mints.Load())
+	}
+}
+
+func TestInstallationRequired(t *testing.T) {
+	app := &App{AppID: 1}
+	if _, err := app.InstallationToken(context.Background()); err == nil {
+		t.Error("expected error without an installation")
+	}
+}
+
+func TestParseInstallationIDs(t *testing.T) {
+	ids, err := ParseInstallationIDs(" 12, 34,,56 ")
+	if err != nil || !slices.Equal(ids, []int64{12, 34, 56}) {
+		t.Errorf("ParseInstallationIDs = %v, %v", ids, err)
+	}
+	if ids, err := ParseInstallationIDs(""); err != nil || ids != nil {
+		t.Errorf("empty list = %v, %v", ids, err)
+	}
+	if _, err := ParseInstallationIDs("12,abc"); err == nil {
+		t.Error("expected error for non-numeric ID")
+	}
+}
+
+func TestRedeliverHook(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Method + " " + r.URL.Path
+		if r.URL.Path == "/app/hook/deliveries/404/attempts" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	app := newTestApp(t, server.URL)
+
+	if err := app.RedeliverHook(context.Background(), 12345); err != nil {
+		t.Fatalf("RedeliverHook: %v", err)
+	}
+	if got != "POST /app/hook/deliveries/12345/attempts" {
+		t.Errorf("unexpected request %q", got)
+	}
+	if err := app.RedeliverHook(context.Background(), 404); err == nil {
+		t.Error("expected error for unknown delivery")
+	}
+}
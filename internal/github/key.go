@@ -0,0 +1,169 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ParsePrivateKey parses a PEM-encoded RSA private key in PKCS#1 ("RSA
+// PRIVATE KEY") or PKCS#8 ("PRIVATE KEY") form. Encrypted PKCS#8 keys
+// ("ENCRYPTED PRIVATE KEY") and keys encrypted with a legacy PEM header
+// (Proc-Type: 4,ENCRYPTED) are decrypted with passphrase.
+func ParsePrivateKey(data, passphrase []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to decode PEM block")
+	}
+
+	der := block.Bytes
+	// Deprecated as weak, but it is what `openssl rsa -aes256` writes.
+	if x509.IsEncryptedPEMBlock(block) { //nolint:staticcheck
+		if len(passphrase) == 0 {
+			return nil, errors.New("private key is encrypted but no passphrase was given")
+		}
+		var err error
+		der, err = x509.DecryptPEMBlock(block, passphrase) //nolint:staticcheck
+		if err != nil {
+			return nil, fmt.Errorf("decrypt private key: %w", err)
+		}
+	}
+
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		if len(passphrase) == 0 {
+			return nil, errors.New("private key is encrypted but no passphrase was given")
+		}
+		var err error
+		if der, err = decryptPKCS8(der, passphrase); err != nil {
+			return nil, err
+		}
+		block.Type = "PRIVATE KEY"
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse PKCS#1 private key: %w", err)
+		}
+		return key, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse PKCS#8 private key: %w", err)
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key is %T, GitHub Apps use RSA", key)
+		}
+		return rsaKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+// signingKey returns the current signing key. PrivateKey and PreviousKey
+// are parsed once, on first use.
+func (a *App) signingKey() (*rsa.PrivateKey, error) {
+	a.keyMu.Lock()
+	defer a.keyMu.Unlock()
+
+	if err := a.loadKeysLocked(); err != nil {
+		return nil, err
+	}
+	return a.keys[0], nil
+}
+
+func (a *App) loadKeysLocked() error {
+	if a.keys != nil {
+		return nil
+	}
+	key, err := ParsePrivateKey(a.PrivateKey, a.KeyPassphrase)
+	if err != nil {
+		return err
+	}
+	keys := []*rsa.PrivateKey{key}
+	if len(a.PreviousKey) > 0 {
+		prev, err := ParsePrivateKey(a.PreviousKey, a.KeyPassphrase)
+		if err != nil {
+			return fmt.Errorf("previous key: %w", err)
+		}
+		keys = append(keys, prev)
+	}
+	a.keys = keys
+	return nil
+}
+
+// SetPrivateKey replaces the signing key with a newly issued PEM key. The
+// key it replaces stays as a fallback, so JWTs keep working while GitHub
+// has only one of the two registered.
+func (a *App) SetPrivateKey(data []byte) error {
+	key, err := ParsePrivateKey(data, a.KeyPassphrase)
+	if err != nil {
+		return err
+	}
+
+	a.keyMu.Lock()
+	defer a.keyMu.Unlock()
+	keys := []*rsa.PrivateKey{key}
+	// Keys from the config that fail to parse are dropped rather than kept.
+	if a.loadKeysLocked() == nil && !a.keys[0].Equal(key) {
+		keys = append(keys, a.keys[0])
+	}
+	a.keys = keys
+	return nil
+}
+
+// useFallbackKey makes the fallback key current after GitHub rejected a JWT
+// signed with failed. If another request already switched away from failed,
+// the keys are left as they are. It returns false if there is no fallback.
+func (a *App) useFallbackKey(failed *rsa.PrivateKey) bool {
+	a.keyMu.Lock()
+	defer a.keyMu.Unlock()
+	if len(a.keys) < 2 {
+		return false
+	}
+	if a.keys[0] == failed {
+		a.keys[0], a.keys[1] = a.keys[1], a.keys[0]
+	}
+	return true
+}
+
+// WatchPrivateKey polls the PEM file at path every interval and loads the
+// key whenever it differs from the last one loaded (initially PrivateKey),
+// until ctx is cancelled. A file that fails to parse is logged and the
+// current key kept.
+func (a *App) WatchPrivateKey(ctx context.Context, path string, interval time.Duration) {
+	last := a.PrivateKey
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			a.log().Error("Failed to read GitHub App private key", "path", path, "error", err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		if err := a.SetPrivateKey(data); err != nil {
+			a.log().Error("Failed to load changed GitHub App private key, keeping current key", "path", path, "error", err)
+			continue
+		}
+		a.log().Info("Reloaded GitHub App private key", "path", path)
+	}
+}
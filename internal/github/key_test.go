@@ -0,0 +1,229 @@
+package github
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return key
+}
+
+func pkcs1PEM(key *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func TestParsePrivateKeyFormats(t *testing.T) {
+	key := newRSAKey(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal PKCS#8: %v", err)
+	}
+	encrypted, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), []byte("hunter2"), x509.PEMCipherAES256) //nolint:staticcheck
+	if err != nil {
+		t.Fatalf("encrypt key: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		pem        []byte
+		passphrase string
+	}{
+		{"PKCS#1", pkcs1PEM(key), ""},
+		{"PKCS#8", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), ""},
+		{"encrypted PKCS#1", pem.EncodeToMemory(encrypted), "hunter2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePrivateKey(tt.pem, []byte(tt.passphrase))
+			if err != nil {
+				t.Fatalf("ParsePrivateKey: %v", err)
+			}
+			if !got.Equal(key) {
+				t.Error("parsed key differs from original")
+			}
+		})
+	}
+
+	if _, err := ParsePrivateKey(pem.EncodeToMemory(encrypted), nil); err == nil {
+		t.Error("expected error for encrypted key without passphrase")
+	}
+	if _, err := ParsePrivateKey(pem.EncodeToMemory(encrypted), []byte("wrong")); err == nil {
+		t.Error("expected error for wrong passphrase")
+	}
+}
+
+func TestParsePrivateKeyEncryptedPKCS8(t *testing.T) {
+	read := func(name string) []byte {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	want, err := ParsePrivateKey(read("key-pkcs8.pem"), nil)
+	if err != nil {
+		t.Fatalf("parse plain key: %v", err)
+	}
+
+	// Written by openssl pkcs8 -topk8 -v2 <cipher> [-v2prf <prf>]
+	for _, name := range []string{"key-pkcs8-aes256.pem", "key-pkcs8-aes128-sha1.pem"} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParsePrivateKey(read(name), []byte("hunter2"))
+			if err != nil {
+				t.Fatalf("ParsePrivateKey: %v", err)
+			}
+			if !got.Equal(want) {
+				t.Error("decrypted key differs from original")
+			}
+			if _, err := ParsePrivateKey(read(name), nil); err == nil {
+				t.Error("expected error without passphrase")
+			}
+			if _, err := ParsePrivateKey(read(name), []byte("wrong")); err == nil {
+				t.Error("expected error for wrong passphrase")
+			}
+		})
+	}
+
+	_, err = ParsePrivateKey(read("key-pkcs8-pbes1.pem"), []byte("hunter2"))
+	if err == nil || !strings.Contains(err.Error(), "PBES2") {
+		t.Errorf("expected legacy PBES1 key rejected, got %v", err)
+	}
+}
+
+func TestParsePrivateKeyRejectsNonRSA(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(ec)
+	if _, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil); err == nil {
+		t.Error("expected error for ECDSA key")
+	}
+	if _, err := ParsePrivateKey([]byte("not a key"), nil); err == nil {
+		t.Error("expected error for non-PEM data")
+	}
+}
+
+// jwtKeyServer mints installation tokens only for JWTs signed by accepted.
+func jwtKeyServer(t *testing.T, accepted *rsa.PrivateKey) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		_, err := jwt.Parse(raw, func(*jwt.Token) (any, error) { return &accepted.PublicKey, nil })
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{"token": "t", "expires_at": time.Now().Add(time.Hour)})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRejectedJWTFallsBackToPreviousKey(t *testing.T) {
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+	server := jwtKeyServer(t, oldKey)
+	app := &App{AppID: 1, InstallationID: 42, APIURL: server.URL, PrivateKey: pkcs1PEM(newKey), PreviousKey: pkcs1PEM(oldKey)}
+
+	if _, err := app.InstallationToken(context.Background()); err != nil {
+		t.Fatalf("expected fallback to the previous key, got %v", err)
+	}
+	if key, _ := app.signingKey(); !key.Equal(oldKey) {
+		t.Error("expected accepted key to become current")
+	}
+}
+
+func TestUseFallbackKeySwitchesOnce(t *testing.T) {
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+	app := &App{PrivateKey: pkcs1PEM(newKey), PreviousKey: pkcs1PEM(oldKey)}
+	failed, err := app.signingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent requests signed with the same key are all rejected.
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !app.useFallbackKey(failed) {
+				t.Error("expected a fallback key")
+			}
+		}()
+	}
+	wg.Wait()
+	if key, _ := app.signingKey(); !key.Equal(oldKey) {
+		t.Error("expected one switch to the fallback key, keys flipped back")
+	}
+}
+
+func TestSetPrivateKeyKeepsOldKeyAsFallback(t *testing.T) {
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+	app := &App{PrivateKey: pkcs1PEM(oldKey)}
+
+	if err := app.SetPrivateKey(pkcs1PEM(newKey)); err != nil {
+		t.Fatalf("SetPrivateKey: %v", err)
+	}
+	if key, _ := app.signingKey(); !key.Equal(newKey) {
+		t.Error("expected new key to sign")
+	}
+	if !app.useFallbackKey(app.keys[0]) {
+		t.Fatal("expected old key kept as fallback")
+	}
+	if key, _ := app.signingKey(); !key.Equal(oldKey) {
+		t.Error("expected fallback to be the old key")
+	}
+	if err := app.SetPrivateKey([]byte("garbage")); err == nil {
+		t.Error("expected error for invalid key")
+	}
+}
+
+func TestWatchPrivateKeyReloadsChangedFile(t *testing.T) {
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+	path := filepath.Join(t.TempDir(), "app.pem")
+	if err := os.WriteFile(path, pkcs1PEM(oldKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	app := &App{PrivateKey: pkcs1PEM(oldKey)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.WatchPrivateKey(ctx, path, 10*time.Millisecond)
+
+	if err := os.WriteFile(path, pkcs1PEM(newKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if key, _ := app.signingKey(); key.Equal(newKey) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected rotated key to be loaded")
+}
@@ -0,0 +1,38 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Meta is the part of GitHub's /meta API response this system uses.
+type Meta struct {
+	Hooks []string `json:"hooks"` // CIDRs webhook deliveries are sent from
+}
+
+// FetchMeta fetches the /meta document at url, e.g. https://api.github.com/meta
+// or a mirror of it. The endpoint needs no authentication.
+func FetchMeta(ctx context.Context, url string) (*Meta, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request meta: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d requesting meta", resp.StatusCode)
+	}
+
+	var meta Meta
+	if err := decodeJSON(resp.Body, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
@@ -0,0 +1,283 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrRateLimited is returned when the API rate limit does not reset soon
+// enough to wait for it.
+var ErrRateLimited = errors.New("github rate limit exceeded")
+
+// maxRateLimitWait bounds how long a request waits for a rate limit reset
+// or Retry-After; longer waits fail with ErrRateLimited instead.
+const maxRateLimitWait = 2 * time.Minute
+
+// retryPolicy controls jittered exponential retries of failed requests.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+var defaultRetry = retryPolicy{maxAttempts: 4, baseDelay: time.Second, maxDelay: 30 * time.Second}
+
+// backoff returns a random wait in [d/2, d], d doubling with each attempt.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.baseDelay
+	for i := 1; i < attempt && d < p.maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, p.maxDelay)
+	return d/2 + rand.N(d/2+1)
+}
+
+// auth selects the credentials a request is sent with.
+type auth int
+
+const (
+	authInstallation auth = iota // installation access token
+	authApp                      // app JWT, for /app endpoints
+)
+
+// do sends an API request and returns the response of the last attempt; the
+// caller checks its status and closes the body. Network errors, 5xx and
+// rate-limited responses are retried with backoff, waiting for Retry-After or
+// the rate limit reset when GitHub sends them. POSTs are not idempotent, so
+// they are only retried if the connection could not be made or GitHub
+// turned them away under a rate limit. A 401 refreshes
+// the installation token, or switches to the fallback app key, and is
+// retried once.
+func (a *App) do(ctx context.Context, method, url string, body []byte, as auth) (*http.Response, error) {
+	policy := a.retry
+	if policy.maxAttempts == 0 {
+		policy = defaultRetry
+	}
+
+	// Installations have their own rate limits; 0 stands for the app's.
+	var limitKey int64
+	if as == authInstallation {
+		id, err := a.installationID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		limitKey = id
+	}
+
+	refreshed := false
+	for attempt := 1; ; attempt++ {
+		if err := a.waitRateLimit(ctx, limitKey); err != nil {
+			return nil, err
+		}
+		req, signer, err := a.newRequest(ctx, method, url, body, as)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := HTTPClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || attempt >= policy.maxAttempts || (!idempotent(method) && !notSent(err)) {
+				return nil, err
+			}
+			wait := policy.backoff(attempt)
+			a.log().Warn("GitHub request failed, retrying", "method", method, "url", url, "attempt", attempt, "retry_in", wait.String(), "error", err)
+			if err := sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		a.observeRateLimit(limitKey, resp.Header)
+
+		if resp.StatusCode == http.StatusUnauthorized && !refreshed {
+			// The cached token may have been revoked, or, for app JWTs, the
+			// key not yet (or no longer) registered mid-rotation.
+			retry := true
+			if as == authInstallation {
+				a.invalidateToken(limitKey)
+			} else if retry = a.useFallbackKey(signer); retry {
+				a.log().Warn("GitHub rejected app JWT, switching to fallback private key")
+			}
+			if retry {
+				discard(resp)
+				refreshed = true
+				attempt--
+				continue
+			}
+		}
+		if !retryable(resp) || attempt >= policy.maxAttempts || (!idempotent(method) && !rateLimited(resp)) {
+			return resp, nil
+		}
+
+		wait, limited := retryAfter(resp.Header, time.Now())
+		if !limited {
+			wait = policy.backoff(attempt)
+		}
+		if wait > maxRateLimitWait {
+			discard(resp)
+			return nil, fmt.Errorf("%w: %s %s: retry in %s", ErrRateLimited, method, url, wait.Round(time.Second))
+		}
+		a.log().Warn("GitHub request throttled or failed, retrying", "method", method, "url", url, "status", resp.StatusCode, "attempt", attempt, "retry_in", wait.String())
+		discard(resp)
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// newRequest returns a request authenticated as as and, for app JWTs, the
+// key the JWT was signed with.
+func (a *App) newRequest(ctx context.Context, method, url string, body []byte, as auth) (*http.Request, *rsa.PrivateKey, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var signer *rsa.PrivateKey
+	switch as {
+	case authApp:
+		var jwtToken string
+		jwtToken, signer, err = a.generateJWT()
+		if err != nil {
+			return nil, nil, fmt.Errorf("generate JWT: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+jwtToken)
+	default:
+		token, err := a.InstallationToken(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("get installation token: %w", err)
+		}
+		req.Header.Set("Authorization", "token "+token)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, signer, nil
+}
+
+// retryable reports whether a response is worth retrying: server errors and
+// rate-limited requests.
+func retryable(resp *http.Response) bool {
+	return resp.StatusCode >= 500 || rateLimited(resp)
+}
+
+// rateLimited reports whether GitHub turned a request away under a rate
+// limit without processing it: 429, and 403s that carry rate limit headers
+// (primary or secondary limits).
+func rateLimited(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0"
+	}
+	return false
+}
+
+// idempotent reports whether a request may be sent again after GitHub saw
+// it. A repeated POST could register a second runner or redeliver twice.
+func idempotent(method string) bool {
+	return method != http.MethodPost && method != http.MethodPatch
+}
+
+// notSent reports whether a request failed before reaching the server,
+// because its host could not be resolved or connected to.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// retryAfter returns how long GitHub asked us to wait, from Retry-After or,
+// when the rate limit is used up, X-RateLimit-Reset.
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	if s := h.Get("Retry-After"); s != "" {
+		if secs, err := strconv.Atoi(s); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+	if h.Get("X-RateLimit-Remaining") == "0" {
+		if reset, ok := rateLimitReset(h); ok {
+			return max(reset.Sub(now), 0), true
+		}
+	}
+	return 0, false
+}
+
+func rateLimitReset(h http.Header) (time.Time, bool) {
+	secs, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0), true
+}
+
+// observeRateLimit remembers when the exhausted rate limit of key resets, so
+// later requests wait instead of being rejected.
+func (a *App) observeRateLimit(key int64, h http.Header) {
+	remaining := h.Get("X-RateLimit-Remaining")
+	if remaining == "" {
+		return
+	}
+	a.rateMu.Lock()
+	defer a.rateMu.Unlock()
+	delete(a.rateLimitedUntil, key)
+	if remaining == "0" {
+		if reset, ok := rateLimitReset(h); ok {
+			if a.rateLimitedUntil == nil {
+				a.rateLimitedUntil = make(map[int64]time.Time)
+			}
+			a.rateLimitedUntil[key] = reset
+		}
+	}
+}
+
+// waitRateLimit blocks until the exhausted rate limit of key resets.
+func (a *App) waitRateLimit(ctx context.Context, key int64) error {
+	a.rateMu.Lock()
+	until := a.rateLimitedUntil[key]
+	a.rateMu.Unlock()
+
+	wait := time.Until(until)
+	if wait <= 0 {
+		return nil
+	}
+	if wait > maxRateLimitWait {
+		return fmt.Errorf("%w until %s", ErrRateLimited, until.Format(time.RFC3339))
+	}
+	a.log().Warn("GitHub rate limit exhausted, waiting for reset", "retry_in", wait.String())
+	return sleep(ctx, wait)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// discard drains and closes a response body so the connection is reused.
+func discard(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+}
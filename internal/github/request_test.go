@@ -0,0 +1,209 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newRetryTestApp returns an App that mints numbered installation tokens and
+// sends every other request to handler, retrying without real delays.
+func newRetryTestApp(t *testing.T, handler http.HandlerFunc) (*App, *atomic.Int32) {
+	t.Helper()
+	var tokens atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/app/installations/42/access_tokens" {
+			n := tokens.Add(1)
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]any{"token": "token-" + strconv.Itoa(int(n)), "expires_at": time.Now().Add(time.Hour)})
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	app := newTestApp(t, server.URL)
+	app.retry = retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+	return app, &tokens
+}
+
+func writeRunners(w http.ResponseWriter) {
+	_ = json.NewEncoder(w).Encode(map[string]any{"total_count": 1, "runners": []Runner{{ID: 1, Name: "eph-1"}}})
+}
+
+func TestDoRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	app, _ := newRetryTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		writeRunners(w)
+	})
+
+	got, err := app.ListRepoRunners(context.Background(), "org", "repo")
+	if err != nil {
+		t.Fatalf("ListRepoRunners: %v", err)
+	}
+	if len(got) != 1 || calls.Load() != 3 {
+		t.Errorf("expected success on third attempt, got %d runners after %d calls", len(got), calls.Load())
+	}
+}
+
+func TestDoGivesUpAfterMaxAttempts(t *testing.T) {
+	var calls atomic.Int32
+	app, _ := newRetryTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	if _, err := app.ListRepoRunners(context.Background(), "org", "repo"); err == nil {
+		t.Fatal("expected error after persistent 503s")
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls.Load())
+	}
+}
+
+func TestDoHonorsRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	app, _ := newRetryTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		writeRunners(w)
+	})
+
+	if _, err := app.ListRepoRunners(context.Background(), "org", "repo"); err != nil {
+		t.Fatalf("ListRepoRunners: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected one retry after 429, got %d calls", calls.Load())
+	}
+}
+
+func TestDoFailsFastOnDistantRateLimitReset(t *testing.T) {
+	var calls atomic.Int32
+	reset := time.Now().Add(time.Hour).Unix()
+	app, _ := newRetryTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+		w.WriteHeader(http.StatusForbidden)
+	})
+
+	_, err := app.ListRepoRunners(context.Background(), "org", "repo")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	// Later calls wait on the remembered reset instead of hitting the API.
+	_, err = app.ListOrgRunners(context.Background(), "org")
+	if !errors.Is(err, ErrRateLimited) || calls.Load() != 1 {
+		t.Errorf("expected second call rejected locally, got %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestDoRefreshesTokenOnUnauthorized(t *testing.T) {
+	app, tokens := newRetryTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeRunners(w)
+	})
+
+	if _, err := app.ListRepoRunners(context.Background(), "org", "repo"); err != nil {
+		t.Fatalf("ListRepoRunners: %v", err)
+	}
+	if tokens.Load() != 2 {
+		t.Errorf("expected token re-minted once, got %d mints", tokens.Load())
+	}
+}
+
+func TestDoDoesNotRetryPosts(t *testing.T) {
+	var calls atomic.Int32
+	app, _ := newRetryTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	if _, err := app.GenerateRepoRunnerToken(context.Background(), "org", "repo"); err == nil {
+		t.Fatal("expected error after 502")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected POST sent once, got %d attempts", calls.Load())
+	}
+}
+
+func TestDoRetriesRateLimitedPosts(t *testing.T) {
+	var calls atomic.Int32
+	app, _ := newRetryTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// Secondary rate limit during a burst of jobs
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{"token": "reg-token"})
+	})
+
+	token, err := app.GenerateRepoRunnerToken(context.Background(), "org", "repo")
+	if err != nil || token != "reg-token" {
+		t.Fatalf("GenerateRepoRunnerToken = %q, %v", token, err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected one retry after the rate limit, got %d calls", calls.Load())
+	}
+}
+
+func TestNotSent(t *testing.T) {
+	// Nothing listens on a closed listener's port.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	_, err = http.Post("http://"+addr, "application/json", nil)
+	if err == nil || !notSent(err) {
+		t.Errorf("refused connection: notSent(%v) = false, want true", err)
+	}
+
+	// The server read the request, then dropped the connection.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		_ = conn.Close()
+	}))
+	defer server.Close()
+	_, err = http.Post(server.URL, "application/json", nil)
+	if err == nil || notSent(err) {
+		t.Errorf("dropped connection: notSent(%v) = true, want false", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	h := http.Header{}
+	h.Set("X-RateLimit-Remaining", "0")
+	h.Set("X-RateLimit-Reset", "1030")
+	if d, ok := retryAfter(h, now); !ok || d != 30*time.Second {
+		t.Errorf("reset header: got %s, %v", d, ok)
+	}
+	h.Set("Retry-After", "5")
+	if d, ok := retryAfter(h, now); !ok || d != 5*time.Second {
+		t.Errorf("Retry-After should win: got %s, %v", d, ok)
+	}
+	if _, ok := retryAfter(http.Header{}, now); ok {
+		t.Error("expected no wait without headers")
+	}
+}
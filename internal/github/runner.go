@@ -1,44 +1,38 @@
 package github
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"strings"
+	"time"
 )
 
 func decodeJSON(r io.Reader, v any) error {
 	return json.NewDecoder(r).Decode(v)
 }
 
-// GenerateRunnerToken creates a short-lived registration token for an org runner.
-func (a *App) GenerateRunnerToken(org string) (string, error) {
-	token, err := a.InstallationToken()
-	if err != nil {
-		return "", fmt.Errorf("get installation token: %w", err)
-	}
-
-	url := fmt.Sprintf("https://api.github.com/orgs/%s/actions/runners/registration-token", org)
-	req, err := http.NewRequest(http.MethodPost, url, nil)
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Authorization", "token "+token)
-	req.Header.Set("Accept", "application/vnd.github+json")
+// GenerateRepoRunnerToken creates a registration token for a specific repo.
+func (a *App) GenerateRepoRunnerToken(ctx context.Context, owner, repo string) (string, error) {
+	return a.registrationToken(ctx, repoScope(owner, repo))
+}
 
-	resp, err := HTTPClient.Do(req)
+func (a *App) registrationToken(ctx context.Context, scope string) (string, error) {
+	url := a.apiURL("%s/actions/runners/registration-token", scope)
+	resp, err := a.do(ctx, http.MethodPost, url, nil, authInstallation)
 	if err != nil {
 		return "", fmt.Errorf("request runner token: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("unexpected status %d requesting runner token", resp.StatusCode)
+		return "", fmt.Errorf("unexpected status %d requesting %s runner token", resp.StatusCode, scope)
 	}
 
 	var result struct {
@@ -50,38 +44,72 @@ func (a *App) GenerateRunnerToken(org string) (string, error) {
 	return result.Token, nil
 }
 
-// GenerateRepoRunnerToken creates a registration token for a specific repo.
-func (a *App) GenerateRepoRunnerToken(owner, repo string) (string, error) {
-	token, err := a.InstallationToken()
-	if err != nil {
-		return "", fmt.Errorf("get installation token: %w", err)
-	}
+// JITConfig is a just-in-time runner configuration. GitHub registers the
+// runner with its name and labels when the config is generated; the encoded
+// config is passed to run.sh --jitconfig and is good for a single job.
+type JITConfig struct {
+	Runner        Runner `json:"runner"`
+	EncodedConfig string `json:"encoded_jit_config"`
+}
+
+// GenerateRepoJITConfig creates a just-in-time config for an ephemeral
+// runner on a specific repo. Repo runners always join the default group.
+func (a *App) GenerateRepoJITConfig(ctx context.Context, owner, repo, name string, labels []string) (*JITConfig, error) {
+	return a.generateJITConfig(ctx, repoScope(owner, repo), name, labels, DefaultRunnerGroupID)
+}
+
+// GenerateOrgJITConfig creates a just-in-time config for an ephemeral
+// org runner in runner group groupID. The group's repository policy decides
+// which repos may use it.
+func (a *App) GenerateOrgJITConfig(ctx context.Context, org, name string, labels []string, groupID int64) (*JITConfig, error) {
+	return a.generateJITConfig(ctx, orgScope(org), name, labels, groupID)
+}
 
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/actions/runners/registration-token", owner, repo)
-	req, err := http.NewRequest(http.MethodPost, url, nil)
+// generateJITConfig requests a config under scope, "repos/<owner>/<repo>"
+// or "orgs/<org>".
+func (a *App) generateJITConfig(ctx context.Context, scope, name string, labels []string, groupID int64) (*JITConfig, error) {
+	body, err := json.Marshal(jitConfigRequest{
+		Name:          name,
+		RunnerGroupID: groupID,
+		Labels:        labels,
+		WorkFolder:    "_work",
+	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	req.Header.Set("Authorization", "token "+token)
-	req.Header.Set("Accept", "application/vnd.github+json")
 
-	resp, err := HTTPClient.Do(req)
+	url := a.apiURL("%s/actions/runners/generate-jitconfig", scope)
+	resp, err := a.do(ctx, http.MethodPost, url, body, authInstallation)
 	if err != nil {
-		return "", fmt.Errorf("request runner token: %w", err)
+		return nil, fmt.Errorf("request jit config: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("unexpected status %d requesting repo runner token", resp.StatusCode)
+		return nil, fmt.Errorf("unexpected status %d requesting jit config", resp.StatusCode)
 	}
 
-	var result struct {
-		Token string `json:"token"`
-	}
+	var result JITConfig
 	if err := decodeJSON(resp.Body, &result); err != nil {
-		return "", err
+		return nil, err
 	}
-	return result.Token, nil
+	if result.EncodedConfig == "" {
+		return nil, fmt.Errorf("empty jit config for runner %s", name)
+	}
+	return &result, nil
+}
+
+func repoScope(owner, repo string) string { return fmt.Sprintf("repos/%s/%s", owner, repo) }
+func orgScope(org string) string          { return "orgs/" + org }
+
+// DefaultRunnerGroupID is the "Default" runner group every repo runner joins.
+const DefaultRunnerGroupID = 1
+
+type jitConfigRequest struct {
+	Name          string   `json:"name"`
+	RunnerGroupID int64    `json:"runner_group_id"`
+	Labels        []string `json:"labels"`
+	WorkFolder    string   `json:"work_folder,omitempty"`
 }
 
 // Runner represents a GitHub Actions self-hosted runner.
@@ -89,34 +117,32 @@ type Runner struct {
 	ID     int64  `json:"id"`
 	Name   string `json:"name"`
 	Status string `json:"status"` // "online" or "offline"
+	Busy   bool   `json:"busy"`   // running a job
 }
 
 // ListRepoRunners returns all self-hosted runners for a repository.
-func (a *App) ListRepoRunners(owner, repo string) ([]Runner, error) {
-	token, err := a.InstallationToken()
-	if err != nil {
-		return nil, fmt.Errorf("get installation token: %w", err)
-	}
+func (a *App) ListRepoRunners(ctx context.Context, owner, repo string) ([]Runner, error) {
+	return a.listRunners(ctx, repoScope(owner, repo))
+}
 
+// ListOrgRunners returns all self-hosted runners registered to an org.
+func (a *App) ListOrgRunners(ctx context.Context, org string) ([]Runner, error) {
+	return a.listRunners(ctx, orgScope(org))
+}
+
+func (a *App) listRunners(ctx context.Context, scope string) ([]Runner, error) {
 	var all []Runner
 	page := 1
 	for {
-		url := fmt.Sprintf("https://api.github.com/repos/%s/%s/actions/runners?per_page=100&page=%d", owner, repo, page)
-		req, err := http.NewRequest(http.MethodGet, url, nil)
-		if err != nil {
-			return nil, err
-		}
-		req.Header.Set("Authorization", "token "+token)
-		req.Header.Set("Accept", "application/vnd.github+json")
-
-		resp, err := HTTPClient.Do(req)
+		url := a.apiURL("%s/actions/runners?per_page=100&page=%d", scope, page)
+		resp, err := a.do(ctx, http.MethodGet, url, nil, authInstallation)
 		if err != nil {
-			return nil, fmt.Errorf("list repo runners: %w", err)
+			return nil, fmt.Errorf("list %s runners: %w", scope, err)
 		}
 
 		if resp.StatusCode != http.StatusOK {
 			_ = resp.Body.Close()
-			return nil, fmt.Errorf("unexpected status %d listing repo runners", resp.StatusCode)
+			return nil, fmt.Errorf("unexpected status %d listing %s runners", resp.StatusCode, scope)
 		}
 
 		var result struct {
@@ -130,7 +156,7 @@ func (a *App) ListRepoRunners(owner, repo string) ([]Runner, error) {
 		}
 
 		all = append(all, result.Runners...)
-		if len(all) >= result.TotalCount {
+		if len(all) >= result.TotalCount || len(result.Runners) == 0 {
 			break
 		}
 		page++
@@ -139,21 +165,18 @@ func (a *App) ListRepoRunners(owner, repo string) ([]Runner, error) {
 }
 
 // RemoveRepoRunner deletes a self-hosted runner from a repository.
-func (a *App) RemoveRepoRunner(owner, repo string, runnerID int64) error {
-	token, err := a.InstallationToken()
-	if err != nil {
-		return fmt.Errorf("get installation token: %w", err)
-	}
+func (a *App) RemoveRepoRunner(ctx context.Context, owner, repo string, runnerID int64) error {
+	return a.removeRunner(ctx, repoScope(owner, repo), runnerID)
+}
 
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/actions/runners/%d", owner, repo, runnerID)
-	req, err := http.NewRequest(http.MethodDelete, url, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "token "+token)
-	req.Header.Set("Accept", "application/vnd.github+json")
+// RemoveOrgRunner deletes a self-hosted runner from an org.
+func (a *App) RemoveOrgRunner(ctx context.Context, org string, runnerID int64) error {
+	return a.removeRunner(ctx, orgScope(org), runnerID)
+}
 
-	resp, err := HTTPClient.Do(req)
+func (a *App) removeRunner(ctx context.Context, scope string, runnerID int64) error {
+	url := a.apiURL("%s/actions/runners/%d", scope, runnerID)
+	resp, err := a.do(ctx, http.MethodDelete, url, nil, authInstallation)
 	if err != nil {
 		return fmt.Errorf("remove runner: %w", err)
 	}
@@ -165,47 +188,82 @@ func (a *App) RemoveRepoRunner(owner, repo string, runnerID int64) error {
 	return nil
 }
 
-// RemoveOfflineRepoRunners removes all offline runners from a repository.
-// Returns the number of runners removed.
-func (a *App) RemoveOfflineRepoRunners(owner, repo string) (int, error) {
-	runners, err := a.ListRepoRunners(owner, repo)
-	if err != nil {
-		return 0, err
+// OfflineRunners returns the offline runners in runners, except those keep
+// returns true for. keep may be nil.
+func OfflineRunners(runners []Runner, keep func(Runner) bool) []Runner {
+	var offline []Runner
+	for _, r := range runners {
+		if r.Status == "offline" && (keep == nil || !keep(r)) {
+			offline = append(offline, r)
+		}
 	}
+	return offline
+}
 
-	removed := 0
-	for _, r := range runners {
-		if r.Status == "offline" {
-			log.Printf("Removing offline runner %s (ID: %d) from %s/%s", r.Name, r.ID, owner, repo)
-			if err := a.RemoveRepoRunner(owner, repo, r.ID); err != nil {
-				log.Printf("Failed to remove runner %d: %v", r.ID, err)
-				continue
+// RunnerGroupID resolves an org runner group name to its ID. Results are
+// cached for the life of the App.
+func (a *App) RunnerGroupID(ctx context.Context, org, name string) (int64, error) {
+	key := org + "/" + name
+	a.groupsMu.Lock()
+	id, ok := a.groups[key]
+	a.groupsMu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	seen := 0
+	for page := 1; ; page++ {
+		url := a.apiURL("orgs/%s/actions/runner-groups?per_page=100&page=%d", org, page)
+		resp, err := a.do(ctx, http.MethodGet, url, nil, authInstallation)
+		if err != nil {
+			return 0, fmt.Errorf("list runner groups: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+			return 0, fmt.Errorf("unexpected status %d listing runner groups of %s", resp.StatusCode, org)
+		}
+
+		var result struct {
+			TotalCount   int           `json:"total_count"`
+			RunnerGroups []RunnerGroup `json:"runner_groups"`
+		}
+		err = decodeJSON(resp.Body, &result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return 0, err
+		}
+
+		for _, g := range result.RunnerGroups {
+			if g.Name == name {
+				a.groupsMu.Lock()
+				if a.groups == nil {
+					a.groups = make(map[string]int64)
+				}
+				a.groups[key] = g.ID
+				a.groupsMu.Unlock()
+				return g.ID, nil
 			}
-			removed++
+		}
+		seen += len(result.RunnerGroups)
+		if seen >= result.TotalCount || len(result.RunnerGroups) == 0 {
+			return 0, fmt.Errorf("runner group %q not found in %s", name, org)
 		}
 	}
-	return removed, nil
 }
 
-// ListInstallationRepos returns all repositories accessible to this installation.
-func (a *App) ListInstallationRepos() ([][2]string, error) {
-	token, err := a.InstallationToken()
-	if err != nil {
-		return nil, fmt.Errorf("get installation token: %w", err)
-	}
+// RunnerGroup is an org runner group.
+type RunnerGroup struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
 
+// ListInstallationRepos returns all repositories accessible to this installation.
+func (a *App) ListInstallationRepos(ctx context.Context) ([][2]string, error) {
 	var repos [][2]string // [owner, name] pairs
 	page := 1
 	for {
-		url := fmt.Sprintf("https://api.github.com/installation/repositories?per_page=100&page=%d", page)
-		req, err := http.NewRequest(http.MethodGet, url, nil)
-		if err != nil {
-			return nil, err
-		}
-		req.Header.Set("Authorization", "token "+token)
-		req.Header.Set("Accept", "application/vnd.github+json")
-
-		resp, err := HTTPClient.Do(req)
+		url := a.apiURL("installation/repositories?per_page=100&page=%d", page)
+		resp, err := a.do(ctx, http.MethodGet, url, nil, authInstallation)
 		if err != nil {
 			return nil, fmt.Errorf("list installation repos: %w", err)
 		}
@@ -233,7 +291,7 @@ func (a *App) ListInstallationRepos() ([][2]string, error) {
 		for _, r := range result.Repositories {
 			repos = append(repos, [2]string{r.Owner.Login, r.Name})
 		}
-		if len(repos) >= result.TotalCount {
+		if len(repos) >= result.TotalCount || len(result.Repositories) == 0 {
 			break
 		}
 		page++
@@ -242,20 +300,51 @@ func (a *App) ListInstallationRepos() ([][2]string, error) {
 }
 
 // VerifyWebhookSignature checks the HMAC-SHA256 signature of a webhook payload.
-// Logs failed attempts with client IP for security monitoring. (#10)
-func VerifyWebhookSignature(payload []byte, signature string, secret []byte, clientIP string) bool {
+func VerifyWebhookSignature(payload []byte, signature string, secret []byte) bool {
+	_, err := MatchWebhookSignature(payload, signature, []WebhookSecret{{Secret: string(secret)}}, time.Now())
+	return err == nil
+}
+
+// Reasons MatchWebhookSignature rejects a delivery. Callers log them with
+// the client IP for security monitoring. (#10)
+var (
+	ErrSignatureFormat   = errors.New("invalid webhook signature format")
+	ErrSignatureMismatch = errors.New("webhook signature mismatch")
+	ErrSecretExpired     = errors.New("webhook signed with expired secret")
+)
+
+// WebhookSecret is a secret deliveries may be signed with. Several are
+// accepted while the secret is rotated in the GitHub App settings.
+type WebhookSecret struct {
+	ID      string    `json:"id"` // names the secret in logs and metrics
+	Secret  string    `json:"secret"`
+	Expires time.Time `json:"expires,omitzero"` // zero never expires
+}
+
+// Expired reports whether the secret is no longer accepted at now.
+func (s WebhookSecret) Expired(now time.Time) bool {
+	return !s.Expires.IsZero() && !now.Before(s.Expires)
+}
+
+// MatchWebhookSignature returns the secret that signed payload. A payload
+// signed with a secret expired at now is rejected with ErrSecretExpired, and
+// that secret is returned so the caller can name it.
+func MatchWebhookSignature(payload []byte, signature string, secrets []WebhookSecret, now time.Time) (WebhookSecret, error) {
 	if !strings.HasPrefix(signature, "sha256=") {
-		log.Printf("SECURITY: invalid signature format from %s", clientIP)
-		return false
+		return WebhookSecret{}, ErrSignatureFormat
 	}
+	got := []byte(signature[7:])
 
-	mac := hmac.New(sha256.New, secret)
-	mac.Write(payload)
-	expected := hex.EncodeToString(mac.Sum(nil))
-
-	valid := hmac.Equal([]byte(signature[7:]), []byte(expected))
-	if !valid {
-		log.Printf("SECURITY: signature mismatch from %s", clientIP)
+	for _, s := range secrets {
+		mac := hmac.New(sha256.New, []byte(s.Secret))
+		mac.Write(payload)
+		if !hmac.Equal(got, []byte(hex.EncodeToString(mac.Sum(nil)))) {
+			continue
+		}
+		if s.Expired(now) {
+			return s, ErrSecretExpired
+		}
+		return s, nil
 	}
-	return valid
+	return WebhookSecret{}, ErrSignatureMismatch
 }
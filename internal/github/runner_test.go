@@ -1,14 +1,16 @@
 package github
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
-	"strings"
 	"testing"
+	"time"
 )
 
 func TestVerifyWebhookSignature_ValidSignature(t *testing.T) {
@@ -18,7 +20,7 @@ func TestVerifyWebhookSignature_ValidSignature(t *testing.T) {
 	// Generate valid signature
 	sig := testSign(payload, secret)
 
-	if !VerifyWebhookSignature(payload, sig, secret, "127.0.0.1") {
+	if !VerifyWebhookSignature(payload, sig, secret) {
 		t.Error("expected valid signature to pass")
 	}
 }
@@ -30,19 +32,19 @@ func TestVerifyWebhookSignature_InvalidSignature(t *testing.T) {
 
 	sig := testSign(payload, wrongSecret)
 
-	if VerifyWebhookSignature(payload, sig, secret, "127.0.0.1") {
+	if VerifyWebhookSignature(payload, sig, secret) {
 		t.Error("expected invalid signature to fail")
 	}
 }
 
 func TestVerifyWebhookSignature_MissingPrefix(t *testing.T) {
-	if VerifyWebhookSignature([]byte("{}"), "noprefixhere", []byte("s"), "127.0.0.1") {
+	if VerifyWebhookSignature([]byte("{}"), "noprefixhere", []byte("s")) {
 		t.Error("expected missing prefix to fail")
 	}
 }
 
 func TestVerifyWebhookSignature_EmptySignature(t *testing.T) {
-	if VerifyWebhookSignature([]byte("{}"), "", []byte("s"), "127.0.0.1") {
+	if VerifyWebhookSignature([]byte("{}"), "", []byte("s")) {
 		t.Error("expected empty signature to fail")
 	}
 }
@@ -55,42 +57,35 @@ func testSign(payload, secret []byte) string {
 
 func TestListRepoRunners_ParsesResponse(t *testing.T) {
 	runners := []Runner{
-		{ID: 1, Name: "eph-repo-1", Status: "online"},
+		{ID: 1, Name: "eph-repo-1", Status: "online", Busy: true},
 		{ID: 2, Name: "eph-repo-2", Status: "offline"},
 	}
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.Contains(r.URL.Path, "/actions/runners") {
-			resp := struct {
-				TotalCount int      `json:"total_count"`
-				Runners    []Runner `json:"runners"`
-			}{
-				TotalCount: len(runners),
-				Runners:    runners,
+		switch r.URL.Path {
+		case "/api/v3/app/installations/42/access_tokens":
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]any{"token": "test-token", "expires_at": time.Now().Add(time.Hour)})
+		case "/api/v3/repos/org/repo/actions/runners":
+			if r.Header.Get("Authorization") != "token test-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
 			}
 			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(resp)
-			return
-		}
-		// Installation token endpoint
-		if strings.Contains(r.URL.Path, "/access_tokens") {
-			w.WriteHeader(http.StatusCreated)
-			json.NewEncoder(w).Encode(map[string]string{"token": "test-token"})
-			return
+			_ = json.NewEncoder(w).Encode(map[string]any{"total_count": len(runners), "runners": runners})
+		default:
+			w.WriteHeader(http.StatusNotFound)
 		}
-		w.WriteHeader(http.StatusNotFound)
 	}))
 	defer server.Close()
 
-	// We can't easily test the full flow without mocking the GitHub API base URL,
-	// but we can verify the Runner struct serialization
-	data, _ := json.Marshal(runners[0])
-	var parsed Runner
-	if err := json.Unmarshal(data, &parsed); err != nil {
-		t.Fatalf("failed to unmarshal runner: %v", err)
+	app := newTestApp(t, server.URL+"/api/v3/")
+	got, err := app.ListRepoRunners(context.Background(), "org", "repo")
+	if err != nil {
+		t.Fatalf("ListRepoRunners: %v", err)
 	}
-	if parsed.ID != 1 || parsed.Name != "eph-repo-1" || parsed.Status != "online" {
-		t.Errorf("unexpected runner: %+v", parsed)
+	if len(got) != 2 || got[0] != runners[0] || got[1] != runners[1] {
+		t.Errorf("unexpected runners: %+v", got)
 	}
 }
 
@@ -120,3 +115,76 @@ func TestRunnerStruct(t *testing.T) {
 		})
 	}
 }
+
+func TestJITConfigResponse(t *testing.T) {
+	body := `{"runner":{"id":23,"name":"eph-repo-1-100","status":"offline"},"encoded_jit_config":"eyJhIjoxfQ=="}`
+
+	var cfg JITConfig
+	if err := json.Unmarshal([]byte(body), &cfg); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if cfg.Runner.ID != 23 || cfg.Runner.Name != "eph-repo-1-100" {
+		t.Errorf("unexpected runner: %+v", cfg.Runner)
+	}
+	if cfg.EncodedConfig != "eyJhIjoxfQ==" {
+		t.Errorf("unexpected encoded config %q", cfg.EncodedConfig)
+	}
+}
+
+func TestJITConfigRequestBody(t *testing.T) {
+	data, err := json.Marshal(jitConfigRequest{
+		Name:          "eph-repo-1-100",
+		RunnerGroupID: DefaultRunnerGroupID,
+		Labels:        []string{"self-hosted", "chef"},
+		WorkFolder:    "_work",
+	})
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	want := `{"name":"eph-repo-1-100","runner_group_id":1,"labels":["self-hosted","chef"],"work_folder":"_work"}`
+	if string(data) != want {
+		t.Errorf("request body = %s, want %s", data, want)
+	}
+}
+
+func TestOfflineRunners(t *testing.T) {
+	runners := []Runner{
+		{ID: 1, Name: "eph-a", Status: "online"},
+		{ID: 2, Name: "eph-b", Status: "offline"},
+		{ID: 3, Name: "eph-c", Status: "offline"},
+	}
+	keep := func(r Runner) bool { return r.Name == "eph-c" }
+
+	got := OfflineRunners(runners, keep)
+	if len(got) != 1 || got[0].ID != 2 {
+		t.Errorf("expected only runner 2, got %v", got)
+	}
+	if got := OfflineRunners(runners, nil); len(got) != 2 {
+		t.Errorf("expected both offline runners with nil keep, got %v", got)
+	}
+}
+
+func TestMatchWebhookSignature(t *testing.T) {
+	payload := []byte(`{"test": true}`)
+	now := time.Now()
+	secrets := []WebhookSecret{
+		{ID: "new", Secret: "new-secret"},
+		{ID: "old", Secret: "old-secret", Expires: now.Add(time.Hour)},
+	}
+
+	if s, err := MatchWebhookSignature(payload, testSign(payload, []byte("old-secret")), secrets, now); err != nil || s.ID != "old" {
+		t.Errorf("expected match on old secret, got %q, %v", s.ID, err)
+	}
+	if s, err := MatchWebhookSignature(payload, testSign(payload, []byte("new-secret")), secrets, now); err != nil || s.ID != "new" {
+		t.Errorf("expected match on new secret, got %q, %v", s.ID, err)
+	}
+	if s, err := MatchWebhookSignature(payload, testSign(payload, []byte("old-secret")), secrets, now.Add(2*time.Hour)); !errors.Is(err, ErrSecretExpired) || s.ID != "old" {
+		t.Errorf("expected expired old secret rejected, got %q, %v", s.ID, err)
+	}
+	if _, err := MatchWebhookSignature(payload, testSign(payload, []byte("other")), secrets, now); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("expected unknown secret rejected, got %v", err)
+	}
+	if _, err := MatchWebhookSignature(payload, "md5=abc", secrets, now); !errors.Is(err, ErrSignatureFormat) {
+		t.Errorf("expected bad format rejected, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+// Package local runs ephemeral runners as processes on the webhook host. It
+// is meant for small jobs and for end-to-end tests without a cloud account.
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+)
+
+var _ provider.Provider = (*Provider)(nil)
+
+// Provider starts one process per runner. Processes are identified by PID
+// and tracked in memory only, so runners outlive a restart untracked.
+type Provider struct {
+	command []string
+	log     *slog.Logger
+
+	mu    sync.Mutex
+	procs map[int]*process
+}
+
+type process struct {
+	cmd  *exec.Cmd
+	inst provider.Instance
+}
+
+// Config holds local provider configuration.
+type Config struct {
+	// Command is run once per runner, e.g. a script that starts the runner
+	// in a Docker container. Runner parameters are passed as RUNNER_*
+	// environment variables; see env.
+	Command []string
+
+	Logger *slog.Logger // defaults to slog.Default()
+}
+
+// New returns a provider that runs cfg.Command for each runner.
+func New(cfg Config) (*Provider, error) {
+	if len(cfg.Command) == 0 {
+		return nil, errors.New("local runner command is required")
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Provider{
+		command: cfg.Command,
+		log:     logger,
+		procs:   make(map[int]*process),
+	}, nil
+}
+
+// env returns the environment for a runner process. The cloud-init template
+// does not apply; the command is expected to run
+// `run.sh --jitconfig "$RUNNER_JITCONFIG"` itself.
+func env(spec provider.Spec, params provider.RunnerParams) []string {
+	return append(os.Environ(),
+		"RUNNER_NAME="+params.RunnerName,
+		"RUNNER_JITCONFIG="+params.JITConfig,
+		"RUNNER_REPO="+params.RunnerRepo,
+		"RUNNER_VERSION="+params.RunnerVersion,
+		"RUNNER_GITHUB_URL="+params.GitHubURL,
+		"RUNNER_IMAGE="+spec.Image,
+		"RUNNER_CALLBACK_URL="+params.CallbackURL,
+		"RUNNER_CALLBACK_TOKEN="+params.CallbackToken,
+		"RUNNER_TRACEPARENT="+params.TraceParent,
+		"RUNNER_TRACE_URL="+params.TraceURL,
+	)
+}
+
+// CreateRunner starts the runner command. The process is not tied to ctx,
+// which only bounds provisioning.
+func (p *Provider) CreateRunner(ctx context.Context, spec provider.Spec, params provider.RunnerParams) (provider.Instance, error) {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Env = env(spec, params)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	// Own process group, so DeleteRunner also stops children (e.g. docker run).
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return provider.Instance{}, fmt.Errorf("start runner process: %w", err)
+	}
+
+	inst := provider.Instance{
+		ID:      cmd.Process.Pid,
+		Name:    params.RunnerName,
+		Tags:    append([]string{"github-runner", "ephemeral"}, spec.Tags...),
+		Created: time.Now(),
+	}
+	if spec.TTL > 0 {
+		inst.Tags = append(inst.Tags, provider.TTLTag(spec.TTL))
+	}
+	p.mu.Lock()
+	p.procs[inst.ID] = &process{cmd: cmd, inst: inst}
+	p.mu.Unlock()
+
+	logger := p.log.With("runner_name", inst.Name, "droplet_id", inst.ID)
+	go func() {
+		err := cmd.Wait()
+		p.mu.Lock()
+		delete(p.procs, inst.ID)
+		p.mu.Unlock()
+		logger.Info("Runner process exited", "error", err)
+	}()
+
+	logger.Info("Started runner process")
+	return inst, nil
+}
+
+// DeleteRunner stops a runner process. Processes that already exited are
+// treated as deleted.
+func (p *Provider) DeleteRunner(ctx context.Context, id int) error {
+	p.mu.Lock()
+	_, ok := p.procs[id]
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if err := syscall.Kill(-id, syscall.SIGTERM); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("stop runner process %d: %w", id, err)
+	}
+	return nil
+}
+
+// ListRunners returns the running processes carrying tag.
+func (p *Provider) ListRunners(ctx context.Context, tag string) ([]provider.Instance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var instances []provider.Instance
+	for _, proc := range p.procs {
+		if proc.inst.HasTag(tag) {
+			instances = append(instances, copyInstance(proc.inst))
+		}
+	}
+	return instances, nil
+}
+
+// DescribeRunner returns a running process, or provider.ErrNotFound.
+func (p *Provider) DescribeRunner(ctx context.Context, id int) (provider.Instance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	proc, ok := p.procs[id]
+	if !ok {
+		return provider.Instance{}, fmt.Errorf("process %d: %w", id, provider.ErrNotFound)
+	}
+	return copyInstance(proc.inst), nil
+}
+
+// UntagRunner removes tag from a running process.
+func (p *Provider) UntagRunner(ctx context.Context, id int, tag string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	proc, ok := p.procs[id]
+	if !ok {
+		return fmt.Errorf("process %d: %w", id, provider.ErrNotFound)
+	}
+	proc.inst.Tags = slices.DeleteFunc(slices.Clone(proc.inst.Tags), func(t string) bool { return t == tag })
+	return nil
+}
+
+// PriceHourly is always zero; local runners use capacity already paid for.
+func (p *Provider) PriceHourly(ctx context.Context, size string) (float64, error) {
+	return 0, nil
+}
+
+func copyInstance(inst provider.Instance) provider.Instance {
+	inst.Tags = slices.Clone(inst.Tags)
+	return inst
+}
@@ -0,0 +1,94 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+)
+
+func waitGone(t *testing.T, p *Provider, id int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := p.DescribeRunner(context.Background(), id); errors.Is(err, provider.ErrNotFound) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("process %d still running", id)
+}
+
+func TestNewRequiresCommand(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Error("expected error without a command")
+	}
+}
+
+func TestRunnerLifecycle(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "env")
+	p, err := New(Config{Command: []string{"/bin/sh", "-c",
+		`echo "$RUNNER_NAME $RUNNER_JITCONFIG" > "` + out + `"; sleep 30`}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx := context.Background()
+
+	inst, err := p.CreateRunner(ctx,
+		provider.Spec{Tags: []string{"pool:default", provider.WarmTag}},
+		provider.RunnerParams{RunnerName: "eph-repo-1-100", JITConfig: "abc"})
+	if err != nil {
+		t.Fatalf("CreateRunner() error = %v", err)
+	}
+	if inst.ID <= 0 || inst.Name != "eph-repo-1-100" || !inst.HasTag("github-runner") {
+		t.Errorf("unexpected instance: %+v", inst)
+	}
+
+	warm, _ := p.ListRunners(ctx, provider.WarmTag)
+	if len(warm) != 1 || warm[0].ID != inst.ID {
+		t.Errorf("expected runner listed as warm, got %+v", warm)
+	}
+	if err := p.UntagRunner(ctx, inst.ID, provider.WarmTag); err != nil {
+		t.Fatalf("UntagRunner() error = %v", err)
+	}
+	if warm, _ := p.ListRunners(ctx, provider.WarmTag); len(warm) != 0 {
+		t.Errorf("expected no warm runners after untag, got %+v", warm)
+	}
+
+	// The command received the runner parameters.
+	deadline := time.Now().Add(5 * time.Second)
+	var data []byte
+	for time.Now().Before(deadline) {
+		if data, _ = os.ReadFile(out); len(data) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := strings.TrimSpace(string(data)); got != "eph-repo-1-100 abc" {
+		t.Errorf("runner env = %q", got)
+	}
+
+	if err := p.DeleteRunner(ctx, inst.ID); err != nil {
+		t.Fatalf("DeleteRunner() error = %v", err)
+	}
+	waitGone(t, p, inst.ID)
+
+	// Deleting an exited runner is not an error.
+	if err := p.DeleteRunner(ctx, inst.ID); err != nil {
+		t.Errorf("DeleteRunner() of exited process error = %v", err)
+	}
+}
+
+func TestRunnerExitRemovesInstance(t *testing.T) {
+	p, _ := New(Config{Command: []string{"/bin/true"}})
+	inst, err := p.CreateRunner(context.Background(), provider.Spec{}, provider.RunnerParams{RunnerName: "eph-x"})
+	if err != nil {
+		t.Fatalf("CreateRunner() error = %v", err)
+	}
+	waitGone(t, p, inst.ID)
+}
@@ -0,0 +1,196 @@
+// Package metrics defines the Prometheus metrics exported by the webhook
+// listener on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+)
+
+const namespace = "github_runners"
+
+var (
+	// Deliveries counts verified webhook deliveries by event and action.
+	Deliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Verified webhook deliveries by event and action.",
+	}, []string{"event", "action"})
+
+	// SignatureFailures counts deliveries rejected for a bad signature.
+	SignatureFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_signature_failures_total",
+		Help:      "Webhook deliveries rejected for an invalid signature.",
+	})
+
+	// SignatureMatches counts verified deliveries by the ID of the secret
+	// that signed them, to confirm a rotated-out secret is unused.
+	SignatureMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_signature_matches_total",
+		Help:      "Deliveries with a valid signature, by the webhook secret that matched.",
+	}, []string{"secret"})
+
+	// SourceRejected counts deliveries rejected for coming from outside the
+	// hook allowlist.
+	SourceRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_source_rejected_total",
+		Help:      "Webhook deliveries rejected for a source address outside the hook allowlist.",
+	})
+
+	// HookAllowlistRefreshFailures counts failed refreshes of the hook
+	// allowlist from the meta API.
+	HookAllowlistRefreshFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hook_allowlist_refresh_failures_total",
+		Help:      "Failed refreshes of the webhook source allowlist from the meta API.",
+	})
+
+	// RateLimited counts queued jobs rejected by the per-repo rate limiter.
+	RateLimited = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_rate_limited_total",
+		Help:      "Queued jobs rejected by the per-repo rate limiter.",
+	})
+
+	// DuplicatesSkipped counts redelivered webhooks and already known jobs.
+	DuplicatesSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_duplicates_skipped_total",
+		Help:      "Duplicate deliveries (kind=delivery) and already provisioned jobs (kind=job) skipped.",
+	}, []string{"kind"})
+
+	// Workers is the size of the provisioning worker pool.
+	Workers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "provision_workers",
+		Help:      "Provisioning workers started.",
+	})
+
+	// WorkersBusy is the number of workers currently provisioning a job.
+	WorkersBusy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "provision_workers_busy",
+		Help:      "Provisioning workers currently running a job.",
+	})
+
+	// ProvisionDuration observes provisioning attempts by pool and result
+	// (success, retry or failed).
+	ProvisionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provision_duration_seconds",
+		Help:      "Time to register a runner and create its instance, per attempt.",
+		Buckets:   []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"pool", "result"})
+
+	// ProviderErrors counts failed instance operations by op (create, delete, describe, untag).
+	ProviderErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_errors_total",
+		Help:      "Failed runner instance operations by op.",
+	}, []string{"op"})
+
+	// Reconciled counts droplets deleted and runners deregistered by the
+	// reconciler, by action (droplet_deleted, runner_removed).
+	Reconciled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_actions_total",
+		Help:      "Droplets deleted and runners deregistered by the reconciler, by action.",
+	}, []string{"action"})
+
+	// GitHubRequests counts GitHub API calls by method and response code.
+	GitHubRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "github_api_requests_total",
+		Help:      "GitHub API requests by method and response status.",
+	}, []string{"method", "code"})
+)
+
+// InstrumentGitHub wraps the transport used for GitHub API calls so every
+// request is counted in GitHubRequests.
+func InstrumentGitHub(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return promhttp.InstrumentRoundTripperCounter(GitHubRequests, rt)
+}
+
+// Handler serves the default registry.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Cleanup holds the results of one cmd/cleanup run. The job is a oneshot, so
+// the gauges are written to a file for the node_exporter textfile collector
+// rather than served.
+type Cleanup struct {
+	registry *prometheus.Registry
+
+	DropletsDeleted     *prometheus.GaugeVec // by reason: released, stale
+	GhostRunnersRemoved prometheus.Gauge
+	StateRecordsPruned  prometheus.Gauge
+	LastSuccess         prometheus.Gauge
+}
+
+// NewCleanup returns cleanup gauges on their own registry.
+func NewCleanup() *Cleanup {
+	reg := prometheus.NewRegistry()
+	factory := promauto.With(reg)
+	return &Cleanup{
+		registry: reg,
+		DropletsDeleted: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cleanup_droplets_deleted",
+			Help:      "Droplets deleted by the last cleanup run, by reason.",
+		}, []string{"reason"}),
+		GhostRunnersRemoved: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cleanup_ghost_runners_removed",
+			Help:      "Offline runners deregistered from GitHub by the last cleanup run.",
+		}),
+		StateRecordsPruned: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cleanup_state_records_pruned",
+			Help:      "State store records pruned by the last cleanup run.",
+		}),
+		LastSuccess: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cleanup_last_success_timestamp_seconds",
+			Help:      "Unix time the last cleanup run finished.",
+		}),
+	}
+}
+
+// WriteTextfile atomically writes the gauges to path in the text exposition
+// format.
+func (c *Cleanup) WriteTextfile(path string) error {
+	return prometheus.WriteToTextfile(path, c.registry)
+}
+
+// KeepLastSuccess sets LastSuccess to the value in the textfile at path, so a
+// failed run does not reset the time of the last successful one. A missing or
+// unreadable file leaves it unset.
+func (c *Cleanup) KeepLastSuccess(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer func() { _ = f.Close() }()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		return
+	}
+	mf, ok := families[namespace+"_cleanup_last_success_timestamp_seconds"]
+	if !ok || len(mf.GetMetric()) == 0 {
+		return
+	}
+	c.LastSuccess.Set(mf.GetMetric()[0].GetGauge().GetValue())
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentGitHub(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	before := testutil.ToFloat64(GitHubRequests.WithLabelValues("post", "201"))
+	client := &http.Client{Transport: InstrumentGitHub(nil)}
+	resp, err := client.Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if got := testutil.ToFloat64(GitHubRequests.WithLabelValues("post", "201")); got != before+1 {
+		t.Errorf("expected request counted, got %v (before %v)", got, before)
+	}
+}
+
+func TestCleanupWriteTextfile(t *testing.T) {
+	c := NewCleanup()
+	c.DropletsDeleted.WithLabelValues("stale").Set(3)
+	c.GhostRunnersRemoved.Set(2)
+
+	path := filepath.Join(t.TempDir(), "cleanup.prom")
+	if err := c.WriteTextfile(path); err != nil {
+		t.Fatalf("WriteTextfile() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read textfile: %v", err)
+	}
+	for _, want := range []string{
+		`github_runners_cleanup_droplets_deleted{reason="stale"} 3`,
+		`github_runners_cleanup_ghost_runners_removed 2`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("textfile missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestCleanupKeepLastSuccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cleanup.prom")
+	c := NewCleanup()
+	c.LastSuccess.Set(1700000000)
+	if err := c.WriteTextfile(path); err != nil {
+		t.Fatalf("WriteTextfile() error = %v", err)
+	}
+
+	failed := NewCleanup()
+	failed.KeepLastSuccess(path)
+	if got := testutil.ToFloat64(failed.LastSuccess); got != 1700000000 {
+		t.Errorf("LastSuccess = %v, want the previous run's 1700000000", got)
+	}
+
+	first := NewCleanup()
+	first.KeepLastSuccess(filepath.Join(t.TempDir(), "missing.prom"))
+	if got := testutil.ToFloat64(first.LastSuccess); got != 0 {
+		t.Errorf("LastSuccess = %v, want 0 without a previous textfile", got)
+	}
+}
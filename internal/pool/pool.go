@@ -0,0 +1,256 @@
+// Package pool maps workflow job labels to runner droplet configurations.
+package pool
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	nameRegex = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	repoRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+/[a-zA-Z0-9._-]+$`)
+)
+
+// Pool is a class of runner droplets selected by job labels. Empty droplet
+// fields fall back to the DigitalOcean client defaults.
+type Pool struct {
+	Name      string   `json:"name"`
+	Labels    []string `json:"labels"` // all must be present on the job
+	Region    string   `json:"region,omitempty"`
+	Size      string   `json:"size,omitempty"`
+	Image     string   `json:"image,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	CloudInit string   `json:"cloud_init,omitempty"` // template path
+	Warm      *Warm    `json:"warm,omitempty"`
+	TTL       string   `json:"ttl,omitempty"`     // runner lifetime, e.g. "2h"; a job's timeout-<n>m label overrides it
+	MaxTTL    string   `json:"max_ttl,omitempty"` // longest lifetime a timeout-<n>m label may ask for; defaults to ttl
+
+	// Scope is where runners register: "repo" (default) for the job's
+	// repository, or "org" for its owning organization. RunnerGroup names an
+	// org runner group and implies org scope.
+	Scope       string `json:"scope,omitempty"`
+	RunnerGroup string `json:"runner_group,omitempty"`
+
+	tmpl   *template.Template
+	ttl    time.Duration
+	maxTTL time.Duration
+}
+
+// Warm keeps pre-booted idle runners registered so jobs skip the droplet boot.
+type Warm struct {
+	Idle     int      `json:"idle"`               // idle runners outside any window
+	Repo     string   `json:"repo"`               // owner/name the idle runners register with; org pools use its owner
+	Schedule []Window `json:"schedule,omitempty"` // first matching window wins
+}
+
+// Window overrides the idle count between Start and End ("15:04", local
+// time). Windows may wrap midnight.
+type Window struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Idle  int    `json:"idle"`
+}
+
+// Target returns the number of idle runners wanted at t.
+func (w *Warm) Target(t time.Time) int {
+	minute := t.Hour()*60 + t.Minute()
+	for _, win := range w.Schedule {
+		start, _ := parseClock(win.Start)
+		end, _ := parseClock(win.End)
+		inside := minute >= start && minute < end
+		if start > end {
+			inside = minute >= start || minute < end
+		}
+		if inside {
+			return win.Idle
+		}
+	}
+	return w.Idle
+}
+
+// parseClock converts "15:04" to minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Template returns the pool's parsed cloud-init template, or nil to use the
+// client default.
+func (p *Pool) Template() *template.Template {
+	return p.tmpl
+}
+
+// RunnerTTL returns the pool's runner lifetime, or 0 for the default.
+func (p *Pool) RunnerTTL() time.Duration {
+	return p.ttl
+}
+
+// MaxRunnerTTL returns the longest runner lifetime a job label may ask for,
+// or 0 for the default lifetime.
+func (p *Pool) MaxRunnerTTL() time.Duration {
+	if p.maxTTL > 0 {
+		return p.maxTTL
+	}
+	return p.ttl
+}
+
+// Registration scopes.
+const (
+	ScopeRepo = "repo"
+	ScopeOrg  = "org"
+)
+
+// OrgScoped reports whether runners register with the job's organization
+// rather than its repository.
+func (p *Pool) OrgScoped() bool {
+	return p.Scope == ScopeOrg || p.RunnerGroup != ""
+}
+
+// Tag returns the droplet tag identifying runners of this pool.
+func (p *Pool) Tag() string {
+	return "pool:" + p.Name
+}
+
+// Matches reports whether every pool label is present in labels.
+func (p *Pool) Matches(labels []string) bool {
+	for _, want := range p.Labels {
+		found := false
+		for _, l := range labels {
+			if strings.EqualFold(strings.TrimSpace(l), want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Set is an ordered list of pools.
+type Set []*Pool
+
+// Match returns the most specific pool whose labels are all present on the
+// job. Ties go to the pool defined first.
+func (s Set) Match(labels []string) (*Pool, bool) {
+	var best *Pool
+	for _, p := range s {
+		if p.Matches(labels) && (best == nil || len(p.Labels) > len(best.Labels)) {
+			best = p
+		}
+	}
+	return best, best != nil
+}
+
+// Get returns the pool with the given name.
+func (s Set) Get(name string) (*Pool, bool) {
+	for _, p := range s {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Load reads pool definitions from a JSON file of the form
+// {"pools": [{"name": "large", "labels": ["self-hosted", "large"], ...}]}
+// and parses each pool's cloud-init template.
+func Load(path string) (Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read pools: %w", err)
+	}
+
+	var file struct {
+		Pools []*Pool `json:"pools"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("decode pools: %w", err)
+	}
+	if len(file.Pools) == 0 {
+		return nil, fmt.Errorf("no pools defined in %s", path)
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range file.Pools {
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("duplicate pool %q", p.Name)
+		}
+		seen[p.Name] = true
+
+		if p.CloudInit != "" {
+			tmpl, err := template.ParseFiles(p.CloudInit)
+			if err != nil {
+				return nil, fmt.Errorf("pool %s: parse cloud-init template: %w", p.Name, err)
+			}
+			p.tmpl = tmpl
+		}
+	}
+	return Set(file.Pools), nil
+}
+
+func (p *Pool) validate() error {
+	if !nameRegex.MatchString(p.Name) {
+		return fmt.Errorf("invalid pool name %q", p.Name)
+	}
+	if len(p.Labels) == 0 {
+		return fmt.Errorf("pool %s: at least one label is required", p.Name)
+	}
+	switch p.Scope {
+	case "", ScopeRepo, ScopeOrg:
+	default:
+		return fmt.Errorf("pool %s: scope must be %q or %q, got %q", p.Name, ScopeRepo, ScopeOrg, p.Scope)
+	}
+	if p.RunnerGroup != "" && p.Scope == ScopeRepo {
+		return fmt.Errorf("pool %s: runner_group requires org scope", p.Name)
+	}
+	if p.TTL != "" {
+		ttl, err := time.ParseDuration(p.TTL)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("pool %s: ttl must be a positive duration such as \"2h\", got %q", p.Name, p.TTL)
+		}
+		p.ttl = ttl
+	}
+	if p.MaxTTL != "" {
+		maxTTL, err := time.ParseDuration(p.MaxTTL)
+		if err != nil || maxTTL <= 0 {
+			return fmt.Errorf("pool %s: max_ttl must be a positive duration such as \"6h\", got %q", p.Name, p.MaxTTL)
+		}
+		if maxTTL < p.ttl {
+			return fmt.Errorf("pool %s: max_ttl %s is shorter than ttl %s", p.Name, p.MaxTTL, p.TTL)
+		}
+		p.maxTTL = maxTTL
+	}
+	if w := p.Warm; w != nil {
+		if !repoRegex.MatchString(w.Repo) {
+			return fmt.Errorf("pool %s: warm repo must be owner/name, got %q", p.Name, w.Repo)
+		}
+		if w.Idle < 0 {
+			return fmt.Errorf("pool %s: warm idle count must not be negative", p.Name)
+		}
+		for _, win := range w.Schedule {
+			if _, err := parseClock(win.Start); err != nil {
+				return fmt.Errorf("pool %s: warm window start %q: %w", p.Name, win.Start, err)
+			}
+			if _, err := parseClock(win.End); err != nil {
+				return fmt.Errorf("pool %s: warm window end %q: %w", p.Name, win.End, err)
+			}
+			if win.Idle < 0 {
+				return fmt.Errorf("pool %s: warm idle count must not be negative", p.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package pool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestMatchMostSpecific(t *testing.T) {
+	pools := Set{
+		{Name: "default", Labels: []string{"self-hosted"}},
+		{Name: "large", Labels: []string{"self-hosted", "large"}},
+		{Name: "arm-large", Labels: []string{"self-hosted", "large", "arm"}},
+	}
+
+	tests := []struct {
+		labels []string
+		want   string
+	}{
+		{[]string{"self-hosted"}, "default"},
+		{[]string{"self-hosted", "large"}, "large"},
+		{[]string{"Self-Hosted", "LARGE"}, "large"},
+		{[]string{"self-hosted", "arm", "large"}, "arm-large"},
+		{[]string{"self-hosted", "arm"}, "default"},
+		{[]string{"ubuntu-latest"}, ""},
+		{nil, ""},
+	}
+
+	for _, tt := range tests {
+		p, ok := pools.Match(tt.labels)
+		got := ""
+		if ok {
+			got = p.Name
+		}
+		if got != tt.want {
+			t.Errorf("Match(%v) = %q, want %q", tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTieGoesToFirst(t *testing.T) {
+	pools := Set{
+		{Name: "first", Labels: []string{"self-hosted", "a"}},
+		{Name: "second", Labels: []string{"self-hosted", "b"}},
+	}
+	p, _ := pools.Match([]string{"self-hosted", "a", "b"})
+	if p.Name != "first" {
+		t.Errorf("expected first pool on tie, got %s", p.Name)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := writeFile(t, dir, "large.yaml.tmpl", "#cloud-config\n# {{.RunnerName}}\n")
+	path := writeFile(t, dir, "pools.json", `{
+		"pools": [
+			{"name": "default", "labels": ["self-hosted"]},
+			{"name": "large", "labels": ["self-hosted", "large"], "size": "s-8vcpu-16gb",
+			 "region": "sfo3", "tags": ["team-chef"], "cloud_init": "`+tmpl+`"}
+		]
+	}`)
+
+	pools, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(pools) != 2 {
+		t.Fatalf("expected 2 pools, got %d", len(pools))
+	}
+
+	large, ok := pools.Get("large")
+	if !ok {
+		t.Fatal("expected large pool")
+	}
+	if large.Size != "s-8vcpu-16gb" || large.Region != "sfo3" || large.Tag() != "pool:large" {
+		t.Errorf("unexpected pool: %+v", large)
+	}
+	if large.Template() == nil {
+		t.Error("expected cloud-init template to be parsed")
+	}
+	if def, _ := pools.Get("default"); def.Template() != nil {
+		t.Error("pool without cloud_init should use the client default template")
+	}
+}
+
+func TestLoadRejectsInvalid(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"empty", `{"pools": []}`},
+		{"bad name", `{"pools": [{"name": "Large Pool", "labels": ["self-hosted"]}]}`},
+		{"no labels", `{"pools": [{"name": "large"}]}`},
+		{"duplicate", `{"pools": [{"name": "a", "labels": ["x"]}, {"name": "a", "labels": ["y"]}]}`},
+		{"missing template", `{"pools": [{"name": "a", "labels": ["x"], "cloud_init": "/nonexistent"}]}`},
+		{"malformed", `{"pools": [`},
+		{"unknown scope", `{"pools": [{"name": "a", "labels": ["x"], "scope": "enterprise"}]}`},
+		{"group on repo scope", `{"pools": [{"name": "a", "labels": ["x"], "scope": "repo", "runner_group": "big"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, dir, "pools.json", tt.content)
+			if _, err := Load(path); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestWarmTarget(t *testing.T) {
+	w := &Warm{
+		Idle: 2,
+		Schedule: []Window{
+			{Start: "19:00", End: "07:00", Idle: 0}, // nights, wraps midnight
+			{Start: "12:00", End: "13:00", Idle: 4},
+		},
+	}
+	at := func(hhmm string) time.Time {
+		tm, _ := time.Parse("15:04", hhmm)
+		return tm
+	}
+
+	tests := []struct {
+		at   string
+		want int
+	}{
+		{"09:30", 2},
+		{"12:15", 4},
+		{"13:00", 2},
+		{"19:00", 0},
+		{"23:59", 0},
+		{"03:00", 0},
+		{"07:00", 2},
+	}
+	for _, tt := range tests {
+		if got := w.Target(at(tt.at)); got != tt.want {
+			t.Errorf("Target(%s) = %d, want %d", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestLoadWarmValidation(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		ok      bool
+	}{
+		{"valid", `{"pools": [{"name": "a", "labels": ["x"], "warm": {"idle": 1, "repo": "org/repo",
+			"schedule": [{"start": "19:00", "end": "07:00", "idle": 0}]}}]}`, true},
+		{"missing repo", `{"pools": [{"name": "a", "labels": ["x"], "warm": {"idle": 1}}]}`, false},
+		{"negative idle", `{"pools": [{"name": "a", "labels": ["x"], "warm": {"idle": -1, "repo": "org/repo"}}]}`, false},
+		{"bad window", `{"pools": [{"name": "a", "labels": ["x"], "warm": {"idle": 1, "repo": "org/repo",
+			"schedule": [{"start": "7pm", "end": "07:00", "idle": 0}]}}]}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, dir, "pools.json", tt.content)
+			_, err := Load(path)
+			if (err == nil) != tt.ok {
+				t.Errorf("Load() error = %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestOrgScoped(t *testing.T) {
+	tests := []struct {
+		pool Pool
+		want bool
+	}{
+		{Pool{}, false},
+		{Pool{Scope: ScopeRepo}, false},
+		{Pool{Scope: ScopeOrg}, true},
+		{Pool{RunnerGroup: "large-runners"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.pool.OrgScoped(); got != tt.want {
+			t.Errorf("%+v.OrgScoped() = %v, want %v", tt.pool, got, tt.want)
+		}
+	}
+}
+
+func TestLoadTTL(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "pools.json", `{"pools": [{"name": "chef", "labels": ["chef"], "ttl": "2h", "max_ttl": "6h"}, {"name": "default", "labels": ["x"], "ttl": "90m"}, {"name": "plain", "labels": ["y"]}]}`)
+	pools, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := pools[0].RunnerTTL(); got != 2*time.Hour {
+		t.Errorf("RunnerTTL() = %v, want 2h", got)
+	}
+	if got := pools[0].MaxRunnerTTL(); got != 6*time.Hour {
+		t.Errorf("MaxRunnerTTL() = %v, want 6h", got)
+	}
+	if got := pools[1].MaxRunnerTTL(); got != 90*time.Minute {
+		t.Errorf("MaxRunnerTTL() = %v, want the pool ttl", got)
+	}
+	if got := pools[2].RunnerTTL(); got != 0 {
+		t.Errorf("RunnerTTL() = %v, want 0 for default", got)
+	}
+	if got := pools[2].MaxRunnerTTL(); got != 0 {
+		t.Errorf("MaxRunnerTTL() = %v, want 0 for default", got)
+	}
+
+	path = writeFile(t, dir, "bad.json", `{"pools": [{"name": "chef", "labels": ["chef"], "ttl": "two hours"}]}`)
+	if _, err := Load(path); err == nil {
+		t.Error("expected error for invalid ttl")
+	}
+	path = writeFile(t, dir, "short.json", `{"pools": [{"name": "chef", "labels": ["chef"], "ttl": "2h", "max_ttl": "1h"}]}`)
+	if _, err := Load(path); err == nil {
+		t.Error("expected error for max_ttl shorter than ttl")
+	}
+}
@@ -0,0 +1,159 @@
+// Package provider defines the backends that run ephemeral runner instances,
+// so the webhook listener is not tied to a single cloud.
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// WarmTag marks idle pre-booted runners kept by the warm pool. It is removed
+// once a job claims the runner.
+const WarmTag = "warm"
+
+// WarmMaxAge is the hard cap on the age of a warm-tagged instance. The warm
+// pool recycles idle runners long before; past it, cleanup deletes them in
+// case no pool manages them anymore.
+const WarmMaxAge = 2 * time.Hour
+
+// DefaultTTL is how long a runner may live when neither its pool nor its
+// job sets a TTL. The in-instance safety net fires SafetyNetMargin later.
+const (
+	DefaultTTL      = 60 * time.Minute
+	SafetyNetMargin = 30 * time.Minute
+)
+
+// ErrNotFound is returned by DescribeRunner for instances that do not exist.
+var ErrNotFound = errors.New("runner instance not found")
+
+// Provider launches and tears down runner instances.
+type Provider interface {
+	// CreateRunner starts one ephemeral runner.
+	CreateRunner(ctx context.Context, spec Spec, params RunnerParams) (Instance, error)
+	// DeleteRunner stops and removes an instance.
+	DeleteRunner(ctx context.Context, id int) error
+	// ListRunners returns all instances carrying tag.
+	ListRunners(ctx context.Context, tag string) ([]Instance, error)
+	// DescribeRunner returns a single instance, or ErrNotFound.
+	DescribeRunner(ctx context.Context, id int) (Instance, error)
+	// UntagRunner removes tag from an instance.
+	UntagRunner(ctx context.Context, id int, tag string) error
+	// PriceHourly returns the hourly USD price of an instance size; empty
+	// means the provider default.
+	PriceHourly(ctx context.Context, size string) (float64, error)
+}
+
+// Spec selects the instance shape and cloud-init template for a runner.
+// Empty fields fall back to the provider defaults.
+type Spec struct {
+	Region    string
+	Size      string
+	Image     string
+	Tags      []string // added to the github-runner and ephemeral tags
+	CloudInit *template.Template
+	TTL       time.Duration // stamped on the instance as a TTL tag if set
+}
+
+// RunnerParams holds parameters for cloud-init template rendering.
+type RunnerParams struct {
+	RunnerName    string
+	JITConfig     string // encoded just-in-time config; name and labels are bound by GitHub
+	RunnerOrg     string
+	RunnerRepo    string
+	CallbackURL   string // self-destruct endpoint on the webhook server
+	CallbackToken string // single-use token authorizing the self-destruct call
+	RunnerVersion string
+	TraceParent   string        // W3C traceparent of the provisioning span; boot phases are its children
+	TraceURL      string        // OTLP/HTTP endpoint for boot spans, empty if not reported
+	TTL           time.Duration // lifetime before cleanup; DefaultTTL if zero
+
+	// GitHubURL is the web URL of the GitHub instance, e.g. https://github.com
+	// or a GitHub Enterprise Server host.
+	GitHubURL string
+	// RunnerDownloadURL is the base URL of actions/runner release downloads.
+	RunnerDownloadURL string
+}
+
+// SafetyNetSeconds is how long the runner waits before destroying itself
+// regardless of state, for use in cloud-init templates.
+func (p RunnerParams) SafetyNetSeconds() int {
+	ttl := p.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return int((ttl + SafetyNetMargin).Seconds())
+}
+
+// Instance is a running runner as reported by its provider.
+type Instance struct {
+	ID          int
+	Name        string
+	Tags        []string
+	Created     time.Time
+	PriceHourly float64
+}
+
+// HasTag reports whether the instance carries tag.
+func (i Instance) HasTag(tag string) bool {
+	for _, t := range i.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	ttlTagRegex   = regexp.MustCompile(`^ttl:([0-9]+)m$`)
+	ttlLabelRegex = regexp.MustCompile(`(?i)^timeout-([0-9]+)(m|h)$`)
+)
+
+// TTLTag returns the tag recording an instance's TTL, rounded up to the
+// minute, e.g. "ttl:120m".
+func TTLTag(ttl time.Duration) string {
+	return fmt.Sprintf("ttl:%dm", int(math.Ceil(ttl.Minutes())))
+}
+
+// TTLFromTags returns the TTL recorded by TTLTag, if any.
+func TTLFromTags(tags []string) (time.Duration, bool) {
+	for _, t := range tags {
+		if m := ttlTagRegex.FindStringSubmatch(t); m != nil {
+			n, err := strconv.Atoi(m[1])
+			if err == nil && n > 0 {
+				return time.Duration(n) * time.Minute, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// TTLFromLabels returns the TTL a job asks for with a label such as
+// "timeout-120m" or "timeout-3h". Callers cap it at the pool's maximum.
+func TTLFromLabels(labels []string) (time.Duration, bool) {
+	for _, l := range labels {
+		m := ttlLabelRegex.FindStringSubmatch(strings.TrimSpace(l))
+		if m == nil {
+			continue
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n <= 0 {
+			continue
+		}
+		unit := time.Minute
+		if strings.EqualFold(m[2], "h") {
+			unit = time.Hour
+		}
+		if n > int(math.MaxInt64/unit) {
+			continue
+		}
+		return time.Duration(n) * unit, true
+	}
+	return 0, false
+}
@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLTagRoundTrip(t *testing.T) {
+	tag := TTLTag(90*time.Minute + time.Second)
+	if tag != "ttl:91m" {
+		t.Errorf("TTLTag() = %q, want rounded up to ttl:91m", tag)
+	}
+	ttl, ok := TTLFromTags([]string{"github-runner", "pool:chef", tag})
+	if !ok || ttl != 91*time.Minute {
+		t.Errorf("TTLFromTags() = %v, %v", ttl, ok)
+	}
+	if _, ok := TTLFromTags([]string{"github-runner", "ttl:soon"}); ok {
+		t.Error("expected malformed TTL tag ignored")
+	}
+}
+
+func TestTTLFromLabels(t *testing.T) {
+	tests := []struct {
+		labels []string
+		want   time.Duration
+		ok     bool
+	}{
+		{[]string{"self-hosted", "timeout-120m"}, 120 * time.Minute, true},
+		{[]string{"self-hosted", "Timeout-3h"}, 3 * time.Hour, true},
+		{[]string{"timeout-999h"}, 999 * time.Hour, true},
+		{[]string{"timeout-9999999999h"}, 0, false},           // overflows time.Duration
+		{[]string{"timeout-99999999999999999999h"}, 0, false}, // overflows int
+		{[]string{"self-hosted", "timeout"}, 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := TTLFromLabels(tt.labels)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("TTLFromLabels(%v) = %v, %v; want %v, %v", tt.labels, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSafetyNetSeconds(t *testing.T) {
+	if got := (RunnerParams{}).SafetyNetSeconds(); got != 5400 {
+		t.Errorf("default safety net = %d, want 5400", got)
+	}
+	if got := (RunnerParams{TTL: 2 * time.Hour}).SafetyNetSeconds(); got != 9000 {
+		t.Errorf("safety net for 2h TTL = %d, want 9000", got)
+	}
+}
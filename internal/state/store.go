@@ -0,0 +1,734 @@
+// Package state persists workflow job and runner droplet history so the
+// webhook listener and the cleanup job share one view of what was provisioned.
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Status is a workflow job lifecycle state.
+type Status string
+
+const (
+	StatusQueued         Status = "queued"
+	StatusWarmReserved   Status = "warm_reserved" // waiting for an idle warm runner
+	StatusProvisioning   Status = "provisioning"
+	StatusDropletCreated Status = "droplet_created"
+	StatusRunnerOnline   Status = "runner_online"
+	StatusCompleted      Status = "completed"
+	StatusFailed         Status = "failed"
+)
+
+// Finished reports whether no further transitions are expected.
+func (s Status) Finished() bool {
+	return s == StatusCompleted || s == StatusFailed
+}
+
+// DropletStatus tracks whether a runner droplet still exists.
+type DropletStatus string
+
+const (
+	DropletActive   DropletStatus = "active"
+	DropletReleased DropletStatus = "released" // job done, deletion requested
+	DropletDeleted  DropletStatus = "deleted"
+)
+
+// Transition is one entry in a job's timeline.
+type Transition struct {
+	Status Status    `json:"status"`
+	At     time.Time `json:"at"`
+	Note   string    `json:"note,omitempty"`
+}
+
+// Job is the recorded lifecycle of a workflow job.
+type Job struct {
+	ID           int64             `json:"id"`
+	Installation int64             `json:"installation,omitempty"` // GitHub App installation that delivered the job
+	Repo         string            `json:"repo"`
+	Pool         string            `json:"pool,omitempty"`
+	Labels       []string          `json:"labels,omitempty"`
+	RunnerName   string            `json:"runner_name,omitempty"`
+	DropletID    int               `json:"droplet_id,omitempty"`
+	Status       Status            `json:"status"`
+	Error        string            `json:"error,omitempty"`
+	Attempts     int               `json:"attempts,omitempty"`
+	NextTry      time.Time         `json:"next_try,omitzero"` // earliest time a queued job may be claimed
+	Trace        map[string]string `json:"trace,omitempty"`   // trace context of the queued delivery
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+	History      []Transition      `json:"history"`
+}
+
+// Droplet is a runner droplet provisioned by the webhook listener.
+type Droplet struct {
+	ID          int           `json:"id"`
+	RunnerName  string        `json:"runner_name"`
+	Pool        string        `json:"pool,omitempty"`
+	Warm        bool          `json:"warm,omitempty"`         // provisioned idle by the warm pool
+	JobID       int64         `json:"job_id,omitempty"`       // job whose queued event provisioned it
+	AssignedJob int64         `json:"assigned_job,omitempty"` // job GitHub actually ran on it
+	Status      DropletStatus `json:"status"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+type snapshot struct {
+	Jobs     map[int64]*Job   `json:"jobs"`
+	Droplets map[int]*Droplet `json:"droplets"`
+	// OfflineRunners maps runner keys to when cleanup first saw them offline.
+	OfflineRunners map[string]time.Time `json:"offline_runners,omitempty"`
+	// Paused maps lowercased repos, or AllRepos, to when provisioning for
+	// them was paused.
+	Paused map[string]time.Time `json:"paused,omitempty"`
+}
+
+// AllRepos is the pause scope covering every repo.
+const AllRepos = "*"
+
+// paused reports whether provisioning for repo is paused.
+func (snap *snapshot) paused(repo string) bool {
+	_, all := snap.Paused[AllRepos]
+	_, one := snap.Paused[strings.ToLower(repo)]
+	return all || one
+}
+
+// Store is a JSON file guarded by an advisory lock, so several processes can
+// read and write it. Every operation reloads the file under the lock.
+type Store struct {
+	path string
+	mu   sync.Mutex
+	mem  *snapshot // used when path is empty
+}
+
+// Open returns a store backed by the file at path, creating its directory if
+// needed. The file itself is created on first write.
+func Open(path string) (*Store, error) {
+	if path == "" {
+		return nil, errors.New("state path is required")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("create state dir: %w", err)
+	}
+	return &Store{path: path}, nil
+}
+
+// NewMemory returns a store that is not persisted. Useful for tests and for
+// running without a state file.
+func NewMemory() *Store {
+	return &Store{mem: newSnapshot()}
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{
+		Jobs:     make(map[int64]*Job),
+		Droplets: make(map[int]*Droplet),
+	}
+}
+
+// view runs fn against the current state under a shared lock.
+func (s *Store) view(fn func(*snapshot) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mem != nil {
+		return fn(s.mem)
+	}
+
+	unlock, err := s.lock(syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snap, err := s.load()
+	if err != nil {
+		return err
+	}
+	return fn(snap)
+}
+
+// update runs fn against the current state under an exclusive lock and
+// persists the result if fn succeeds and changed anything, so idle workers
+// polling the queue do not rewrite the file.
+func (s *Store) update(fn func(*snapshot) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mem != nil {
+		return fn(s.mem)
+	}
+
+	unlock, err := s.lock(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snap, err := s.load()
+	if err != nil {
+		return err
+	}
+	before, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("encode state: %w", err)
+	}
+	if err := fn(snap); err != nil {
+		return err
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("encode state: %w", err)
+	}
+	if bytes.Equal(data, before) {
+		return nil
+	}
+	return s.save(data)
+}
+
+func (s *Store) lock(how int) (func(), error) {
+	f, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open state lock: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("lock state: %w", err)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
+
+func (s *Store) load() (*snapshot, error) {
+	snap := newSnapshot()
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return snap, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("decode state: %w", err)
+	}
+	if snap.Jobs == nil {
+		snap.Jobs = make(map[int64]*Job)
+	}
+	if snap.Droplets == nil {
+		snap.Droplets = make(map[int]*Droplet)
+	}
+	return snap, nil
+}
+
+// save writes data to a temp file, syncs it and renames it over the state
+// file, so readers never see a partial file and a crash or power loss leaves
+// either the old or the new state.
+func (s *Store) save(data []byte) error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("sync state: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("replace state: %w", err)
+	}
+
+	// Persist the rename itself.
+	dir, err := os.Open(filepath.Dir(s.path))
+	if err != nil {
+		return fmt.Errorf("sync state dir: %w", err)
+	}
+	defer func() { _ = dir.Close() }()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("sync state dir: %w", err)
+	}
+	return nil
+}
+
+func (j *Job) transition(status Status, note string, now time.Time) {
+	j.Status = status
+	j.UpdatedAt = now
+	j.History = append(j.History, Transition{Status: status, At: now, Note: note})
+}
+
+// Enqueue records a queued job. It returns false if the job is already
+// queued, in flight or done, so duplicate deliveries do not provision twice.
+// A job that previously failed is queued again from scratch. installation is
+// the app installation to provision with, 0 for the default. trace carries
+// the delivery's trace context to the worker that provisions the job.
+func (s *Store) Enqueue(id, installation int64, repo, pool string, labels []string, trace map[string]string) (bool, error) {
+	return s.enqueue(StatusQueued, id, installation, repo, pool, labels, trace)
+}
+
+// EnqueueReserved is Enqueue for a job that holds an idle warm runner. It is
+// recorded as StatusWarmReserved, so no worker claims it.
+func (s *Store) EnqueueReserved(id, installation int64, repo, pool string, labels []string, trace map[string]string) (bool, error) {
+	return s.enqueue(StatusWarmReserved, id, installation, repo, pool, labels, trace)
+}
+
+func (s *Store) enqueue(status Status, id, installation int64, repo, pool string, labels []string, trace map[string]string) (bool, error) {
+	created := false
+	err := s.update(func(snap *snapshot) error {
+		now := time.Now()
+		if job, ok := snap.Jobs[id]; ok {
+			if job.Status != StatusFailed {
+				return nil
+			}
+			job.Installation = installation
+			job.Attempts = 0
+			job.NextTry = time.Time{}
+			job.Error = ""
+			job.Trace = trace
+			job.transition(status, "requeued after failure", now)
+			created = true
+			return nil
+		}
+		job := &Job{ID: id, Installation: installation, Repo: repo, Pool: pool, Labels: labels, Trace: trace, CreatedAt: now}
+		job.transition(status, "", now)
+		snap.Jobs[id] = job
+		created = true
+		return nil
+	})
+	return created, err
+}
+
+// Transition moves a known job to status, recording note in its timeline.
+func (s *Store) Transition(id int64, status Status, note string) error {
+	return s.update(func(snap *snapshot) error {
+		job, ok := snap.Jobs[id]
+		if !ok {
+			return fmt.Errorf("job %d not found", id)
+		}
+		job.transition(status, note, time.Now())
+		if status == StatusFailed {
+			job.Error = note
+		}
+		return nil
+	})
+}
+
+// ClaimNext moves the oldest queued job that is due at now to provisioning
+// and returns it, skipping repos whose provisioning is paused. The claim is
+// atomic, so concurrent workers (or processes) never receive the same job.
+func (s *Store) ClaimNext(now time.Time) (Job, bool, error) {
+	var claimed Job
+	found := false
+	err := s.update(func(snap *snapshot) error {
+		var next *Job
+		for _, j := range snap.Jobs {
+			if j.Status != StatusQueued || j.NextTry.After(now) || snap.paused(j.Repo) {
+				continue
+			}
+			if next == nil || j.CreatedAt.Before(next.CreatedAt) {
+				next = j
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		next.Attempts++
+		next.transition(StatusProvisioning, fmt.Sprintf("attempt %d", next.Attempts), now)
+		claimed = copyJob(next)
+		found = true
+		return nil
+	})
+	return claimed, found, err
+}
+
+// Retry returns a job in status from to the queue, to be claimed again no
+// earlier than at. It returns false, changing nothing, if the job has moved
+// on, e.g. completed while it was being provisioned.
+func (s *Store) Retry(id int64, from Status, reason string, at time.Time) (bool, error) {
+	requeued := false
+	err := s.update(func(snap *snapshot) error {
+		job, ok := snap.Jobs[id]
+		if !ok {
+			return fmt.Errorf("job %d not found", id)
+		}
+		if job.Status != from {
+			return nil
+		}
+		job.Error = reason
+		job.NextTry = at
+		job.transition(StatusQueued, "retry: "+reason, time.Now())
+		requeued = true
+		return nil
+	})
+	return requeued, err
+}
+
+// RequeueInterrupted returns jobs left in provisioning, or waiting on a warm
+// runner reservation, by a previous process to the queue. Call it once at
+// startup before any worker claims jobs.
+func (s *Store) RequeueInterrupted() (int, error) {
+	requeued := 0
+	err := s.update(func(snap *snapshot) error {
+		now := time.Now()
+		for _, j := range snap.Jobs {
+			if j.Status == StatusProvisioning || j.Status == StatusWarmReserved {
+				j.NextTry = time.Time{}
+				j.transition(StatusQueued, "requeued after restart", now)
+				requeued++
+			}
+		}
+		return nil
+	})
+	return requeued, err
+}
+
+// RecordDroplet links a newly created runner droplet to the job it was
+// provisioned for.
+func (s *Store) RecordDroplet(jobID int64, runnerName string, dropletID int) error {
+	return s.update(func(snap *snapshot) error {
+		job, ok := snap.Jobs[jobID]
+		if !ok {
+			return fmt.Errorf("job %d not found", jobID)
+		}
+		now := time.Now()
+		snap.Droplets[dropletID] = &Droplet{
+			ID:         dropletID,
+			RunnerName: runnerName,
+			Pool:       job.Pool,
+			JobID:      jobID,
+			Status:     DropletActive,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		job.RunnerName = runnerName
+		job.DropletID = dropletID
+		job.transition(StatusDropletCreated, fmt.Sprintf("droplet %d", dropletID), now)
+		return nil
+	})
+}
+
+// RecordWarmDroplet records an idle runner droplet created by the warm pool
+// ahead of any job.
+func (s *Store) RecordWarmDroplet(pool, runnerName string, dropletID int) error {
+	return s.update(func(snap *snapshot) error {
+		now := time.Now()
+		snap.Droplets[dropletID] = &Droplet{
+			ID:         dropletID,
+			RunnerName: runnerName,
+			Pool:       pool,
+			Warm:       true,
+			Status:     DropletActive,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		return nil
+	})
+}
+
+// AssignRunner records that jobID started on runnerName and returns the
+// runner's droplet. It returns false if the runner was not provisioned by
+// this system.
+func (s *Store) AssignRunner(jobID int64, repo, runnerName string) (Droplet, bool, error) {
+	var assigned Droplet
+	found := false
+	err := s.update(func(snap *snapshot) error {
+		d := findActiveByRunner(snap, runnerName)
+		if d == nil {
+			return nil
+		}
+		found = true
+
+		now := time.Now()
+		d.AssignedJob = jobID
+		d.UpdatedAt = now
+
+		job, ok := snap.Jobs[jobID]
+		if !ok {
+			job = &Job{ID: jobID, Repo: repo, CreatedAt: now}
+			snap.Jobs[jobID] = job
+		}
+		job.RunnerName = runnerName
+		job.DropletID = d.ID
+		job.transition(StatusRunnerOnline, runnerName, now)
+		assigned = *d
+		return nil
+	})
+	return assigned, found, err
+}
+
+// Complete marks a job completed and releases the droplet that should be torn
+// down for it. Jobs that ran on one of our runners are matched by runner name.
+// Jobs cancelled before assignment (empty runnerName) release the droplet
+// provisioned for them, unless GitHub has since handed it another job.
+func (s *Store) Complete(jobID int64, runnerName, conclusion string) (Droplet, bool, error) {
+	var released Droplet
+	found := false
+	err := s.update(func(snap *snapshot) error {
+		now := time.Now()
+		if job, ok := snap.Jobs[jobID]; ok && !job.Status.Finished() {
+			job.transition(StatusCompleted, conclusion, now)
+		}
+
+		var d *Droplet
+		if runnerName != "" {
+			d = findActiveByRunner(snap, runnerName)
+		} else {
+			for _, c := range snap.Droplets {
+				if c.JobID == jobID && c.Status == DropletActive && (c.AssignedJob == 0 || c.AssignedJob == jobID) {
+					d = c
+					break
+				}
+			}
+		}
+		if d == nil {
+			return nil
+		}
+
+		d.Status = DropletReleased
+		d.UpdatedAt = now
+		released = *d
+		found = true
+		return nil
+	})
+	return released, found, err
+}
+
+// MarkDropletDeleted records that a droplet no longer exists.
+func (s *Store) MarkDropletDeleted(id int) error {
+	return s.update(func(snap *snapshot) error {
+		d, ok := snap.Droplets[id]
+		if !ok {
+			return nil
+		}
+		d.Status = DropletDeleted
+		d.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// Job returns a copy of the job with the given ID.
+func (s *Store) Job(id int64) (Job, bool, error) {
+	var job Job
+	found := false
+	err := s.view(func(snap *snapshot) error {
+		if j, ok := snap.Jobs[id]; ok {
+			job = copyJob(j)
+			found = true
+		}
+		return nil
+	})
+	return job, found, err
+}
+
+// Jobs returns all recorded jobs, oldest first.
+func (s *Store) Jobs() ([]Job, error) {
+	var jobs []Job
+	err := s.view(func(snap *snapshot) error {
+		for _, j := range snap.Jobs {
+			jobs = append(jobs, copyJob(j))
+		}
+		return nil
+	})
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].CreatedAt.Before(jobs[k].CreatedAt) })
+	return jobs, err
+}
+
+// Droplet returns the recorded droplet with the given ID.
+func (s *Store) Droplet(id int) (Droplet, bool, error) {
+	var d Droplet
+	found := false
+	err := s.view(func(snap *snapshot) error {
+		if rec, ok := snap.Droplets[id]; ok {
+			d = *rec
+			found = true
+		}
+		return nil
+	})
+	return d, found, err
+}
+
+// RunnerDroplet returns the droplet running runnerName that has not been
+// deleted yet.
+func (s *Store) RunnerDroplet(runnerName string) (Droplet, bool, error) {
+	var d Droplet
+	found := false
+	err := s.view(func(snap *snapshot) error {
+		for _, rec := range snap.Droplets {
+			if rec.RunnerName == runnerName && rec.Status != DropletDeleted {
+				d = *rec
+				found = true
+				return nil
+			}
+		}
+		return nil
+	})
+	return d, found, err
+}
+
+// HasRunner reports whether a droplet was recorded for runnerName, deleted
+// or not, until its record is pruned.
+func (s *Store) HasRunner(runnerName string) (bool, error) {
+	found := false
+	err := s.view(func(snap *snapshot) error {
+		for _, rec := range snap.Droplets {
+			if rec.RunnerName == runnerName {
+				found = true
+				return nil
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// Droplets returns all recorded droplets, oldest first.
+func (s *Store) Droplets() ([]Droplet, error) {
+	var droplets []Droplet
+	err := s.view(func(snap *snapshot) error {
+		for _, d := range snap.Droplets {
+			droplets = append(droplets, *d)
+		}
+		return nil
+	})
+	sort.Slice(droplets, func(i, k int) bool { return droplets[i].CreatedAt.Before(droplets[k].CreatedAt) })
+	return droplets, err
+}
+
+// RecordOfflineRunners records the runners identified by keys as seen
+// offline at now and forgets every other runner, so a runner that comes back
+// online starts over. It returns when each key was first seen offline.
+func (s *Store) RecordOfflineRunners(keys []string, now time.Time) (map[string]time.Time, error) {
+	since := make(map[string]time.Time, len(keys))
+	err := s.update(func(snap *snapshot) error {
+		for _, k := range keys {
+			first, ok := snap.OfflineRunners[k]
+			if !ok {
+				first = now
+			}
+			since[k] = first
+		}
+		snap.OfflineRunners = since
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return maps.Clone(since), nil
+}
+
+// OfflineRunners returns when each runner recorded by RecordOfflineRunners
+// was first seen offline.
+func (s *Store) OfflineRunners() (map[string]time.Time, error) {
+	var since map[string]time.Time
+	err := s.view(func(snap *snapshot) error {
+		since = maps.Clone(snap.OfflineRunners)
+		return nil
+	})
+	return since, err
+}
+
+// Pause stops provisioning for repo ("owner/name"), or for every repo if
+// repo is AllRepos. Queued jobs wait until provisioning is resumed.
+func (s *Store) Pause(repo string) error {
+	return s.update(func(snap *snapshot) error {
+		key := strings.ToLower(repo)
+		if _, ok := snap.Paused[key]; ok {
+			return nil
+		}
+		if snap.Paused == nil {
+			snap.Paused = make(map[string]time.Time)
+		}
+		snap.Paused[key] = time.Now()
+		return nil
+	})
+}
+
+// Resume undoes Pause for repo or AllRepos. Resuming AllRepos does not
+// resume repos paused individually. It returns false if repo was not paused.
+func (s *Store) Resume(repo string) (bool, error) {
+	resumed := false
+	err := s.update(func(snap *snapshot) error {
+		key := strings.ToLower(repo)
+		_, resumed = snap.Paused[key]
+		delete(snap.Paused, key)
+		return nil
+	})
+	return resumed, err
+}
+
+// Paused returns when each paused repo, or AllRepos, was paused.
+func (s *Store) Paused() (map[string]time.Time, error) {
+	var paused map[string]time.Time
+	err := s.view(func(snap *snapshot) error {
+		paused = maps.Clone(snap.Paused)
+		return nil
+	})
+	return paused, err
+}
+
+// IsPaused reports whether provisioning for repo is paused, by itself or
+// globally.
+func (s *Store) IsPaused(repo string) (bool, error) {
+	paused := false
+	err := s.view(func(snap *snapshot) error {
+		paused = snap.paused(repo)
+		return nil
+	})
+	return paused, err
+}
+
+// Prune removes finished jobs and deleted droplets not updated since
+// olderThan ago. Returns the number of records removed.
+func (s *Store) Prune(olderThan time.Duration) (int, error) {
+	removed := 0
+	err := s.update(func(snap *snapshot) error {
+		cutoff := time.Now().Add(-olderThan)
+		for id, j := range snap.Jobs {
+			if j.Status.Finished() && j.UpdatedAt.Before(cutoff) {
+				delete(snap.Jobs, id)
+				removed++
+			}
+		}
+		for id, d := range snap.Droplets {
+			if d.Status == DropletDeleted && d.UpdatedAt.Before(cutoff) {
+				delete(snap.Droplets, id)
+				removed++
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+func findActiveByRunner(snap *snapshot, runnerName string) *Droplet {
+	for _, d := range snap.Droplets {
+		if d.RunnerName == runnerName && d.Status == DropletActive {
+			return d
+		}
+	}
+	return nil
+}
+
+func copyJob(j *Job) Job {
+	c := *j
+	c.Labels = append([]string(nil), j.Labels...)
+	c.History = append([]Transition(nil), j.History...)
+	return c
+}
@@ -0,0 +1,375 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newFileStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	return s, path
+}
+
+func TestJobLifecycle(t *testing.T) {
+	s := NewMemory()
+
+	created, err := s.Enqueue(1, 0, "org/repo", "default", []string{"self-hosted"}, nil)
+	if err != nil || !created {
+		t.Fatalf("Enqueue() = %v, %v; want true, nil", created, err)
+	}
+	if created, _ := s.Enqueue(1, 0, "org/repo", "default", nil, nil); created {
+		t.Error("second Enqueue of same job should report existing")
+	}
+
+	if err := s.Transition(1, StatusProvisioning, ""); err != nil {
+		t.Fatalf("transition: %v", err)
+	}
+	if err := s.RecordDroplet(1, "eph-repo-1-100", 1001); err != nil {
+		t.Fatalf("record droplet: %v", err)
+	}
+	if _, ok, err := s.AssignRunner(1, "org/repo", "eph-repo-1-100"); err != nil || !ok {
+		t.Fatalf("AssignRunner() = %v, %v; want true, nil", ok, err)
+	}
+
+	d, ok, err := s.Complete(1, "eph-repo-1-100", "success")
+	if err != nil || !ok {
+		t.Fatalf("Complete() = %v, %v; want true, nil", ok, err)
+	}
+	if d.ID != 1001 || d.Status != DropletReleased {
+		t.Errorf("unexpected released droplet: %+v", d)
+	}
+
+	job, _, _ := s.Job(1)
+	want := []Status{StatusQueued, StatusProvisioning, StatusDropletCreated, StatusRunnerOnline, StatusCompleted}
+	if len(job.History) != len(want) {
+		t.Fatalf("expected %d transitions, got %+v", len(want), job.History)
+	}
+	for i, st := range want {
+		if job.History[i].Status != st {
+			t.Errorf("transition %d = %s, want %s", i, job.History[i].Status, st)
+		}
+	}
+
+	if _, ok, _ := s.Complete(1, "eph-repo-1-100", "success"); ok {
+		t.Error("released droplet should not be released twice")
+	}
+}
+
+func TestTransitionUnknownJob(t *testing.T) {
+	s := NewMemory()
+	if err := s.Transition(42, StatusFailed, "boom"); err == nil {
+		t.Error("expected error for unknown job")
+	}
+	if err := s.RecordDroplet(42, "eph-x", 1); err == nil {
+		t.Error("expected error recording droplet for unknown job")
+	}
+	if _, ok, _ := s.Droplet(1); ok {
+		t.Error("droplet should not be recorded for unknown job")
+	}
+}
+
+func TestFailedJobRecordsError(t *testing.T) {
+	s := NewMemory()
+	_, _ = s.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	_ = s.Transition(1, StatusFailed, "create droplet: quota exceeded")
+
+	job, _, _ := s.Job(1)
+	if job.Status != StatusFailed || job.Error != "create droplet: quota exceeded" {
+		t.Errorf("unexpected job: %+v", job)
+	}
+}
+
+func TestCompleteJobRanOnOtherRunner(t *testing.T) {
+	s := NewMemory()
+	_, _ = s.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	_, _ = s.Enqueue(2, 0, "org/repo", "default", nil, nil)
+	_ = s.RecordDroplet(1, "eph-repo-1-100", 1001)
+	_ = s.RecordDroplet(2, "eph-repo-2-100", 1002)
+
+	// GitHub hands job 2 to the runner provisioned for job 1.
+	_, _, _ = s.AssignRunner(2, "org/repo", "eph-repo-1-100")
+
+	d, ok, _ := s.Complete(2, "eph-repo-1-100", "success")
+	if !ok || d.ID != 1001 {
+		t.Fatalf("expected droplet 1001 released, got %+v (ok=%v)", d, ok)
+	}
+
+	// Job 2's own droplet is untouched and can still pick up work.
+	other, _, _ := s.Droplet(1002)
+	if other.Status != DropletActive {
+		t.Errorf("droplet 1002 should still be active, got %s", other.Status)
+	}
+}
+
+func TestCompleteCancelledBeforeAssignment(t *testing.T) {
+	s := NewMemory()
+	_, _ = s.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	_ = s.RecordDroplet(1, "eph-repo-1-100", 1001)
+
+	d, ok, _ := s.Complete(1, "", "cancelled")
+	if !ok || d.ID != 1001 {
+		t.Fatalf("expected droplet 1001 released, got %+v (ok=%v)", d, ok)
+	}
+}
+
+func TestCompleteCancelledKeepsReassignedRunner(t *testing.T) {
+	s := NewMemory()
+	_, _ = s.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	_ = s.RecordDroplet(1, "eph-repo-1-100", 1001)
+	_, _, _ = s.AssignRunner(2, "org/repo", "eph-repo-1-100")
+
+	// Job 1 was cancelled, but its droplet is now running job 2.
+	if _, ok, _ := s.Complete(1, "", "cancelled"); ok {
+		t.Error("droplet running another job must not be released")
+	}
+}
+
+func TestAssignUnknownRunner(t *testing.T) {
+	s := NewMemory()
+	if _, ok, _ := s.AssignRunner(1, "org/repo", "some-other-runner"); ok {
+		t.Error("AssignRunner should ignore runners this system did not provision")
+	}
+	if _, ok, _ := s.Complete(1, "some-other-runner", "success"); ok {
+		t.Error("Complete should ignore unknown runners")
+	}
+	if _, ok, _ := s.Job(1); ok {
+		t.Error("unknown runner should not create a job record")
+	}
+}
+
+func TestFileStoreSharedAcrossInstances(t *testing.T) {
+	s1, path := newFileStore(t)
+	trace := map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	_, _ = s1.Enqueue(1, 0, "org/repo", "default", []string{"self-hosted"}, trace)
+	_ = s1.RecordDroplet(1, "eph-repo-1-100", 1001)
+
+	// A second process (e.g. cmd/cleanup) opening the same file sees the data.
+	s2, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	job, ok, err := s2.Job(1)
+	if err != nil || !ok {
+		t.Fatalf("Job() = %v, %v; want true, nil", ok, err)
+	}
+	if job.DropletID != 1001 || job.Status != StatusDropletCreated {
+		t.Errorf("unexpected job: %+v", job)
+	}
+	if job.Trace["traceparent"] != trace["traceparent"] {
+		t.Errorf("expected trace context to persist, got %v", job.Trace)
+	}
+
+	if err := s2.MarkDropletDeleted(1001); err != nil {
+		t.Fatalf("mark deleted: %v", err)
+	}
+	d, _, _ := s1.Droplet(1001)
+	if d.Status != DropletDeleted {
+		t.Errorf("expected first instance to see deletion, got %s", d.Status)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	s, _ := newFileStore(t)
+	_, _ = s.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	_ = s.RecordDroplet(1, "eph-repo-1-100", 1001)
+	_, _, _ = s.Complete(1, "", "cancelled")
+	_ = s.MarkDropletDeleted(1001)
+	_, _ = s.Enqueue(2, 0, "org/repo", "default", nil, nil) // still queued, must survive
+
+	time.Sleep(10 * time.Millisecond)
+	removed, err := s.Prune(5 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("expected 2 records pruned, got %d", removed)
+	}
+
+	jobs, _ := s.Jobs()
+	if len(jobs) != 1 || jobs[0].ID != 2 {
+		t.Errorf("expected only job 2 to remain, got %+v", jobs)
+	}
+}
+
+func TestClaimNextOrderAndBackoff(t *testing.T) {
+	s := NewMemory()
+	_, _ = s.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	time.Sleep(time.Millisecond)
+	_, _ = s.Enqueue(2, 0, "org/repo", "default", nil, nil)
+
+	job, ok, _ := s.ClaimNext(time.Now())
+	if !ok || job.ID != 1 || job.Status != StatusProvisioning || job.Attempts != 1 {
+		t.Fatalf("expected oldest job claimed, got %+v (ok=%v)", job, ok)
+	}
+
+	// Job 1 is retried in the future; job 2 is next.
+	_, _ = s.Retry(1, StatusProvisioning, "create droplet: 503", time.Now().Add(time.Hour))
+	job, ok, _ = s.ClaimNext(time.Now())
+	if !ok || job.ID != 2 {
+		t.Fatalf("expected job 2 claimed, got %+v (ok=%v)", job, ok)
+	}
+	if _, ok, _ := s.ClaimNext(time.Now()); ok {
+		t.Error("no job should be due")
+	}
+
+	job, ok, _ = s.ClaimNext(time.Now().Add(2 * time.Hour))
+	if !ok || job.ID != 1 || job.Attempts != 2 {
+		t.Errorf("expected job 1 on second attempt, got %+v (ok=%v)", job, ok)
+	}
+}
+
+func TestEnqueueReserved(t *testing.T) {
+	s := NewMemory()
+	if created, _ := s.EnqueueReserved(1, 0, "org/repo", "warm", nil, nil); !created {
+		t.Fatal("expected job created")
+	}
+	if job, _, _ := s.Job(1); job.Status != StatusWarmReserved || len(job.History) != 1 {
+		t.Fatalf("expected job recorded warm_reserved directly, got %+v", job)
+	}
+	if _, ok, _ := s.ClaimNext(time.Now()); ok {
+		t.Error("reserved job must not be claimed")
+	}
+	if created, _ := s.EnqueueReserved(1, 0, "org/repo", "warm", nil, nil); created {
+		t.Error("duplicate delivery must not enqueue again")
+	}
+}
+
+func TestRetrySkipsJobsThatMovedOn(t *testing.T) {
+	s := NewMemory()
+	_, _ = s.Enqueue(1, 0, "org/repo", "warm", nil, nil)
+	_ = s.Transition(1, StatusWarmReserved, "warm")
+
+	// The job completed on the warm runner before its reservation expired.
+	_, _, _ = s.Complete(1, "", "success")
+	requeued, err := s.Retry(1, StatusWarmReserved, "warm runner not available", time.Now())
+	if err != nil || requeued {
+		t.Fatalf("Retry of completed job = %v, %v; want false", requeued, err)
+	}
+	if job, _, _ := s.Job(1); job.Status != StatusCompleted {
+		t.Errorf("expected job left completed, got %s", job.Status)
+	}
+}
+
+func TestClaimNextSkipsPausedRepos(t *testing.T) {
+	s := NewMemory()
+	_, _ = s.Enqueue(1, 0, "org/Paused", "default", nil, nil)
+	time.Sleep(time.Millisecond)
+	_, _ = s.Enqueue(2, 0, "org/other", "default", nil, nil)
+
+	_ = s.Pause("org/paused")
+	job, ok, _ := s.ClaimNext(time.Now())
+	if !ok || job.ID != 2 {
+		t.Fatalf("expected job of unpaused repo claimed, got %+v (ok=%v)", job, ok)
+	}
+
+	_ = s.Pause(AllRepos)
+	if resumed, _ := s.Resume("org/paused"); !resumed {
+		t.Error("expected repo to have been paused")
+	}
+	if _, ok, _ := s.ClaimNext(time.Now()); ok {
+		t.Fatal("expected nothing claimed while paused globally")
+	}
+	if paused, _ := s.IsPaused("org/any"); !paused {
+		t.Error("expected global pause to cover every repo")
+	}
+
+	_, _ = s.Resume(AllRepos)
+	if job, ok, _ := s.ClaimNext(time.Now()); !ok || job.ID != 1 {
+		t.Errorf("expected job 1 claimed after resume, got %+v (ok=%v)", job, ok)
+	}
+	if paused, _ := s.Paused(); len(paused) != 0 {
+		t.Errorf("expected no pauses left, got %v", paused)
+	}
+}
+
+func TestRequeueInterrupted(t *testing.T) {
+	s, _ := newFileStore(t)
+	_, _ = s.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	_, _, _ = s.ClaimNext(time.Now())
+
+	n, err := s.RequeueInterrupted()
+	if err != nil || n != 1 {
+		t.Fatalf("RequeueInterrupted() = %d, %v; want 1, nil", n, err)
+	}
+	job, _, _ := s.Job(1)
+	if job.Status != StatusQueued {
+		t.Errorf("expected job requeued, got %s", job.Status)
+	}
+}
+
+func TestWarmDropletClaimedByJob(t *testing.T) {
+	s := NewMemory()
+	if err := s.RecordWarmDroplet("chef", "warm-chef-1", 2001); err != nil {
+		t.Fatalf("record warm droplet: %v", err)
+	}
+	_, _ = s.Enqueue(7, 0, "org/cookbooks", "chef", nil, nil)
+	_ = s.Transition(7, StatusWarmReserved, "chef")
+
+	d, ok, err := s.AssignRunner(7, "org/cookbooks", "warm-chef-1")
+	if err != nil || !ok {
+		t.Fatalf("AssignRunner() = %v, %v; want true, nil", ok, err)
+	}
+	if !d.Warm || d.Pool != "chef" || d.AssignedJob != 7 {
+		t.Errorf("unexpected warm droplet: %+v", d)
+	}
+
+	job, _, _ := s.Job(7)
+	if job.DropletID != 2001 || job.Status != StatusRunnerOnline {
+		t.Errorf("unexpected job: %+v", job)
+	}
+
+	d, ok, _ = s.Complete(7, "warm-chef-1", "success")
+	if !ok || d.ID != 2001 {
+		t.Errorf("expected warm droplet released, got %+v (ok=%v)", d, ok)
+	}
+}
+
+func TestRecordOfflineRunnersKeepsFirstSighting(t *testing.T) {
+	s, _ := newFileStore(t)
+	t0 := time.Now().Add(-time.Hour)
+
+	if _, err := s.RecordOfflineRunners([]string{"org/repo/a", "org/repo/b"}, t0); err != nil {
+		t.Fatalf("RecordOfflineRunners: %v", err)
+	}
+	// b came back online; c went offline.
+	since, err := s.RecordOfflineRunners([]string{"org/repo/a", "org/repo/c"}, time.Now())
+	if err != nil {
+		t.Fatalf("RecordOfflineRunners: %v", err)
+	}
+	if !since["org/repo/a"].Equal(t0) {
+		t.Errorf("expected first sighting of a kept, got %v", since["org/repo/a"])
+	}
+	if since["org/repo/c"].Equal(t0) {
+		t.Error("expected c first seen now")
+	}
+
+	recorded, _ := s.OfflineRunners()
+	if _, ok := recorded["org/repo/b"]; ok || len(recorded) != 2 {
+		t.Errorf("expected b forgotten, got %v", recorded)
+	}
+}
+
+func TestIdleClaimDoesNotRewriteFile(t *testing.T) {
+	s, path := newFileStore(t)
+	_, _ = s.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	_, _, _ = s.ClaimNext(time.Now())
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+
+	if _, ok, _ := s.ClaimNext(time.Now()); ok {
+		t.Fatal("expected nothing left to claim")
+	}
+	after, _ := os.Stat(path)
+	if !os.SameFile(before, after) {
+		t.Error("expected an empty claim to leave the state file alone")
+	}
+}
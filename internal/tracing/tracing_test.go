@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestSetupExportsToCollector(t *testing.T) {
+	var received atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" {
+			received.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	shutdown, err := Setup(context.Background(), "test", collector.URL)
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	_, span := otel.Tracer("test").Start(context.Background(), "work")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if received.Load() == 0 {
+		t.Error("expected spans exported to the collector")
+	}
+}
+
+func TestSetupWithoutEndpoint(t *testing.T) {
+	shutdown, err := Setup(context.Background(), "test", "")
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+	if c := Carrier(context.Background()); c != nil {
+		t.Errorf("expected no trace context without a span, got %v", c)
+	}
+}
+
+func TestCarrierRoundTrip(t *testing.T) {
+	if _, err := Setup(context.Background(), "test", ""); err != nil {
+		t.Fatal(err)
+	}
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	tp := TraceParent(ctx)
+	if !strings.Contains(tp, traceID.String()) || !strings.Contains(tp, spanID.String()) {
+		t.Errorf("unexpected traceparent %q", tp)
+	}
+
+	got := trace.SpanContextFromContext(Extract(context.Background(), Carrier(ctx)))
+	if got.TraceID() != traceID || got.SpanID() != spanID {
+		t.Errorf("extracted %v, want trace %s span %s", got, traceID, spanID)
+	}
+}
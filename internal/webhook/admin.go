@@ -0,0 +1,297 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/metrics"
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+	"github.com/thomasvincent/github-runners-infra/internal/state"
+)
+
+// The admin API lets operators inspect and steer the listener:
+//
+//	GET    /admin/jobs                 unfinished jobs and their droplets (?all=true for every job)
+//	GET    /admin/jobs/{id}            one job's timeline, droplet and live instance
+//	DELETE /admin/droplets/{id}        force-delete a runner droplet
+//	DELETE /admin/runners/{id}         deregister a runner (?repo=owner/name or ?org=login)
+//	GET    /admin/pause                paused repos ("*" is global)
+//	POST   /admin/pause, /admin/resume pause or resume provisioning (?repo=owner/name, global without)
+//
+// Requests must present a client certificate verified by the admin TLS
+// listener, or the admin bearer token.
+
+// adminJob is a job together with the droplet provisioned for it.
+type adminJob struct {
+	state.Job
+	Droplet  *state.Droplet `json:"droplet,omitempty"`
+	Instance *adminInstance `json:"instance,omitempty"` // live provider view, job detail only
+}
+
+type adminInstance struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Tags        []string  `json:"tags,omitempty"`
+	Created     time.Time `json:"created"`
+	PriceHourly float64   `json:"price_hourly,omitempty"`
+}
+
+// AdminHandler returns the /admin API, authorized by token or a verified
+// client certificate. An empty token leaves mTLS as the only way in.
+func (h *Handler) AdminHandler(token []byte) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/jobs", h.adminListJobs)
+	mux.HandleFunc("GET /admin/jobs/{id}", h.adminJob)
+	mux.HandleFunc("DELETE /admin/droplets/{id}", h.adminDeleteDroplet)
+	mux.HandleFunc("DELETE /admin/runners/{id}", h.adminDeregisterRunner)
+	mux.HandleFunc("GET /admin/pause", h.adminListPaused)
+	mux.HandleFunc("POST /admin/pause", h.adminPause)
+	mux.HandleFunc("POST /admin/resume", h.adminResume)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(r, token) {
+			h.log.Warn("Unauthorized admin request", "security", true, "client_ip", h.clientIP(r), "method", r.Method, "path", r.URL.Path)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func adminAuthorized(r *http.Request, token []byte) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && len(token) > 0 && hmac.Equal([]byte(bearer), token)
+}
+
+func (h *Handler) adminListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := h.store.Jobs()
+	if err != nil {
+		h.adminError(w, "Failed to list jobs", err)
+		return
+	}
+	droplets, err := h.store.Droplets()
+	if err != nil {
+		h.adminError(w, "Failed to list droplets", err)
+		return
+	}
+	byID := make(map[int]state.Droplet, len(droplets))
+	for _, d := range droplets {
+		byID[d.ID] = d
+	}
+
+	all := r.URL.Query().Get("all") == "true"
+	out := []adminJob{}
+	for _, job := range jobs {
+		if job.Status.Finished() && !all {
+			continue
+		}
+		aj := adminJob{Job: job}
+		if d, ok := byID[job.DropletID]; ok {
+			aj.Droplet = &d
+		}
+		out = append(out, aj)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) adminJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid job id", http.StatusBadRequest)
+		return
+	}
+	job, ok, err := h.store.Job(id)
+	if err != nil {
+		h.adminError(w, "Failed to look up job", err)
+		return
+	}
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	out := adminJob{Job: job}
+	if job.DropletID != 0 {
+		if d, ok, err := h.store.Droplet(job.DropletID); err == nil && ok {
+			out.Droplet = &d
+		}
+		if out.Droplet == nil || out.Droplet.Status != state.DropletDeleted {
+			ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+			inst, err := h.provider.DescribeRunner(ctx, job.DropletID)
+			cancel()
+			switch {
+			case err == nil:
+				out.Instance = &adminInstance{ID: inst.ID, Name: inst.Name, Tags: inst.Tags, Created: inst.Created, PriceHourly: inst.PriceHourly}
+			case !errors.Is(err, provider.ErrNotFound):
+				h.jobLog(job).Warn("Failed to describe droplet for admin request", "error", err)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) adminDeleteDroplet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid droplet id", http.StatusBadRequest)
+		return
+	}
+	logger := h.log.With("droplet_id", id, "client_ip", h.clientIP(r))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+	defer cancel()
+	// Only runner droplets may be deleted; the token must not reach the
+	// account's other droplets.
+	inst, err := h.provider.DescribeRunner(ctx, id)
+	switch {
+	case errors.Is(err, provider.ErrNotFound):
+		if err := h.store.MarkDropletDeleted(id); err != nil {
+			logger.Error("Failed to record droplet deleted", "error", err)
+		}
+		http.Error(w, "droplet not found", http.StatusNotFound)
+		return
+	case err != nil:
+		metrics.ProviderErrors.WithLabelValues("describe").Inc()
+		logger.Error("Failed to look up droplet to force-delete", "error", err)
+		http.Error(w, "lookup failed", http.StatusBadGateway)
+		return
+	case !inst.HasTag(runnerTag):
+		logger.Warn("Refused to force-delete droplet that is not a runner", "security", true)
+		http.Error(w, "droplet is not a runner", http.StatusConflict)
+		return
+	case h.excluded(inst):
+		http.Error(w, "droplet is tagged "+h.excludeTag+", remove the tag first", http.StatusConflict)
+		return
+	}
+	if err := h.provider.DeleteRunner(ctx, id); err != nil && !errors.Is(err, provider.ErrNotFound) {
+		metrics.ProviderErrors.WithLabelValues("delete").Inc()
+		logger.Error("Failed to force-delete droplet", "error", err)
+		http.Error(w, "delete failed", http.StatusBadGateway)
+		return
+	}
+	if err := h.store.MarkDropletDeleted(id); err != nil {
+		logger.Error("Failed to record droplet deleted", "error", err)
+	}
+	logger.Info("Force-deleted droplet via admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) adminDeregisterRunner(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid runner id", http.StatusBadRequest)
+		return
+	}
+	repo, org := r.URL.Query().Get("repo"), r.URL.Query().Get("org")
+	owner := org
+	switch {
+	case repo != "" && org == "" && repoRegex.MatchString(repo):
+		owner, _, _ = strings.Cut(repo, "/")
+	case repo == "" && org != "" && safeNameRegex.MatchString(org):
+	default:
+		http.Error(w, "exactly one of repo=owner/name or org=login is required", http.StatusBadRequest)
+		return
+	}
+	logger := h.log.With("runner_id", id, "owner", owner, "repo", repo, "client_ip", h.clientIP(r))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	installation, err := h.githubApp.AccountInstallation(ctx, owner)
+	if err != nil {
+		logger.Error("Failed to resolve installation for runner", "error", err)
+		http.Error(w, "installation lookup failed", http.StatusBadGateway)
+		return
+	}
+	ctx = gh.WithInstallation(ctx, installation)
+	if repo != "" {
+		name, _ := strings.CutPrefix(repo, owner+"/")
+		err = h.githubApp.RemoveRepoRunner(ctx, owner, name, id)
+	} else {
+		err = h.githubApp.RemoveOrgRunner(ctx, org, id)
+	}
+	if err != nil {
+		logger.Error("Failed to deregister runner", "error", err)
+		http.Error(w, "deregister failed", http.StatusBadGateway)
+		return
+	}
+	logger.Info("Deregistered runner via admin API", "installation_id", installation)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) adminListPaused(w http.ResponseWriter, _ *http.Request) {
+	paused, err := h.store.Paused()
+	if err != nil {
+		h.adminError(w, "Failed to list paused repos", err)
+		return
+	}
+	if paused == nil {
+		paused = map[string]time.Time{}
+	}
+	writeJSON(w, http.StatusOK, paused)
+}
+
+func (h *Handler) adminPause(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pauseScope(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.Pause(repo); err != nil {
+		h.adminError(w, "Failed to pause provisioning", err)
+		return
+	}
+	h.log.Info("Paused provisioning via admin API", "repo", repo, "client_ip", h.clientIP(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) adminResume(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pauseScope(w, r)
+	if !ok {
+		return
+	}
+	resumed, err := h.store.Resume(repo)
+	if err != nil {
+		h.adminError(w, "Failed to resume provisioning", err)
+		return
+	}
+	if !resumed {
+		http.Error(w, "not paused", http.StatusNotFound)
+		return
+	}
+	h.log.Info("Resumed provisioning via admin API", "repo", repo, "client_ip", h.clientIP(r))
+	h.notifyWorkers()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pauseScope returns the repo named by ?repo=, or state.AllRepos if none.
+func pauseScope(w http.ResponseWriter, r *http.Request) (string, bool) {
+	repo := r.URL.Query().Get("repo")
+	if repo == "" {
+		return state.AllRepos, true
+	}
+	if !repoRegex.MatchString(repo) {
+		http.Error(w, "invalid repo", http.StatusBadRequest)
+		return "", false
+	}
+	return repo, true
+}
+
+func (h *Handler) adminError(w http.ResponseWriter, msg string, err error) {
+	h.log.Error(msg, "error", err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
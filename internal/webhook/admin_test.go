@@ -0,0 +1,182 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+	"github.com/thomasvincent/github-runners-infra/internal/state"
+)
+
+const testAdminToken = "test-admin-token"
+
+func adminRequest(h *Handler, method, target, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.AdminHandler([]byte(testAdminToken)).ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRequiresAuth(t *testing.T) {
+	h := newTestHandler()
+	if w := adminRequest(h, http.MethodGet, "/admin/jobs", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", w.Code)
+	}
+	if w := adminRequest(h, http.MethodGet, "/admin/jobs", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for wrong token, got %d", w.Code)
+	}
+
+	// A client certificate verified by the TLS listener is enough.
+	req := httptest.NewRequest(http.MethodGet, "/admin/jobs", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	w := httptest.NewRecorder()
+	h.AdminHandler(nil).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 with verified client certificate, got %d", w.Code)
+	}
+}
+
+func TestAdminListsUnfinishedJobsWithDroplets(t *testing.T) {
+	h := newTestHandler()
+	_, _ = h.store.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	_ = h.store.RecordDroplet(1, "eph-repo-1-100", 1001)
+	_, _ = h.store.Enqueue(2, 0, "org/repo", "default", nil, nil)
+	_ = h.store.Transition(2, state.StatusFailed, "boom")
+
+	w := adminRequest(h, http.MethodGet, "/admin/jobs", testAdminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var jobs []adminJob
+	if err := json.NewDecoder(w.Body).Decode(&jobs); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != 1 {
+		t.Fatalf("expected only unfinished job 1, got %+v", jobs)
+	}
+	if jobs[0].Droplet == nil || jobs[0].Droplet.ID != 1001 {
+		t.Errorf("expected droplet 1001 attached, got %+v", jobs[0].Droplet)
+	}
+
+	w = adminRequest(h, http.MethodGet, "/admin/jobs?all=true", testAdminToken)
+	jobs = nil
+	_ = json.NewDecoder(w.Body).Decode(&jobs)
+	if len(jobs) != 2 {
+		t.Errorf("expected both jobs with all=true, got %d", len(jobs))
+	}
+}
+
+func TestAdminJobTimeline(t *testing.T) {
+	h := newTestHandler()
+	fake := newFakeProvider()
+	h.provider = fake
+	_, _ = h.store.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	_ = h.store.Transition(1, state.StatusProvisioning, "")
+
+	w := adminRequest(h, http.MethodGet, "/admin/jobs/1", testAdminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var job adminJob
+	if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(job.History) != 2 || job.History[1].Status != state.StatusProvisioning {
+		t.Errorf("expected queued then provisioning, got %+v", job.History)
+	}
+
+	if w := adminRequest(h, http.MethodGet, "/admin/jobs/99", testAdminToken); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown job, got %d", w.Code)
+	}
+}
+
+func TestAdminForceDeletesDroplet(t *testing.T) {
+	h := newTestHandler()
+	fake := newFakeProvider()
+	h.provider = fake
+	_, _ = h.store.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	_ = h.store.RecordDroplet(1, "eph-repo-1-100", 1001)
+	fake.instances[1001] = provider.Instance{ID: 1001, Name: "eph-repo-1-100", Tags: []string{runnerTag}}
+
+	if w := adminRequest(h, http.MethodDelete, "/admin/droplets/1001", testAdminToken); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if deleted := fake.deletedIDs(); len(deleted) != 1 || deleted[0] != 1001 {
+		t.Errorf("expected droplet 1001 deleted, got %v", deleted)
+	}
+	if d, _, _ := h.store.Droplet(1001); d.Status != state.DropletDeleted {
+		t.Errorf("expected droplet recorded deleted, got %s", d.Status)
+	}
+}
+
+func TestAdminRefusesToDeleteOtherDroplets(t *testing.T) {
+	h := newTestHandler()
+	fake := newFakeProvider()
+	h.provider = fake
+	fake.instances[7] = provider.Instance{ID: 7, Name: "db-primary", Tags: []string{"database"}}
+	fake.instances[8] = provider.Instance{ID: 8, Name: "eph-repo-8", Tags: []string{runnerTag, "debug"}}
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/admin/droplets/7", http.StatusConflict}, // not a runner
+		{"/admin/droplets/8", http.StatusConflict}, // under debugging
+		{"/admin/droplets/9", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := adminRequest(h, http.MethodDelete, tt.target, testAdminToken); w.Code != tt.want {
+			t.Errorf("DELETE %s: expected %d, got %d", tt.target, tt.want, w.Code)
+		}
+	}
+	if deleted := fake.deletedIDs(); len(deleted) != 0 {
+		t.Errorf("expected nothing deleted, got %v", deleted)
+	}
+}
+
+func TestAdminDeregisterRequiresScope(t *testing.T) {
+	h := newTestHandler()
+	if w := adminRequest(h, http.MethodDelete, "/admin/runners/5", testAdminToken); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without repo or org, got %d", w.Code)
+	}
+	if w := adminRequest(h, http.MethodDelete, "/admin/runners/5?repo=org/repo&org=org", testAdminToken); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 with both repo and org, got %d", w.Code)
+	}
+}
+
+func TestAdminPauseAndResume(t *testing.T) {
+	h := newTestHandler()
+	_, _ = h.store.Enqueue(1, 0, "org/repo", "default", nil, nil)
+
+	if w := adminRequest(h, http.MethodPost, "/admin/pause?repo=org/repo", testAdminToken); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if _, ok, _ := h.store.ClaimNext(time.Now()); ok {
+		t.Fatal("expected paused repo's job not claimed")
+	}
+
+	w := adminRequest(h, http.MethodGet, "/admin/pause", testAdminToken)
+	var paused map[string]time.Time
+	_ = json.NewDecoder(w.Body).Decode(&paused)
+	if _, ok := paused["org/repo"]; !ok || len(paused) != 1 {
+		t.Errorf("expected org/repo paused, got %v", paused)
+	}
+
+	if w := adminRequest(h, http.MethodPost, "/admin/resume", testAdminToken); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 resuming a global pause that was never set, got %d", w.Code)
+	}
+	if w := adminRequest(h, http.MethodPost, "/admin/resume?repo=org/repo", testAdminToken); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if _, ok, _ := h.store.ClaimNext(time.Now()); !ok {
+		t.Error("expected job claimed after resume")
+	}
+}
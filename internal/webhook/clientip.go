@@ -0,0 +1,184 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/metrics"
+)
+
+// ParsePrefixes parses CIDRs and bare IP addresses, which match only
+// themselves.
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client that sent r. X-Forwarded-For
+// is only believed as far as it was written by trusted proxies: entries are
+// read right to left, skipping trusted hops, and the first untrusted address
+// is the client. Without trusted proxies the header is ignored.
+func (h *Handler) clientAddr(r *http.Request) netip.Addr {
+	addr := remoteAddr(r)
+	if !addr.IsValid() || !containsAddr(h.trustedProxies, addr) {
+		return addr
+	}
+
+	hops := r.Header.Values("X-Forwarded-For")
+	for i := len(hops) - 1; i >= 0; i-- {
+		entries := strings.Split(hops[i], ",")
+		for j := len(entries) - 1; j >= 0; j-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(entries[j]))
+			if err != nil {
+				// A trusted proxy would not write this; stop at the last
+				// hop we can vouch for.
+				return addr
+			}
+			addr = hop.Unmap()
+			if !containsAddr(h.trustedProxies, addr) {
+				return addr
+			}
+		}
+	}
+	return addr
+}
+
+// clientIP is clientAddr for logs, falling back to RemoteAddr if it cannot
+// be parsed.
+func (h *Handler) clientIP(r *http.Request) string {
+	if addr := h.clientAddr(r); addr.IsValid() {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
+
+func remoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// sourceAllowed reports whether deliveries from addr are accepted: always
+// without a hook allowlist, otherwise only from its ranges.
+func (h *Handler) sourceAllowed(addr netip.Addr) bool {
+	h.hooksMu.RLock()
+	defer h.hooksMu.RUnlock()
+	return h.hookRanges == nil || (addr.IsValid() && containsAddr(h.hookRanges, addr))
+}
+
+// SetHookAllowlist replaces the ranges webhook deliveries are accepted from.
+// An empty list is refused, since it would reject every delivery.
+func (h *Handler) SetHookAllowlist(prefixes []netip.Prefix) error {
+	if len(prefixes) == 0 {
+		return errors.New("empty hook allowlist")
+	}
+	h.hooksMu.Lock()
+	defer h.hooksMu.Unlock()
+	h.hookRanges = append([]netip.Prefix(nil), prefixes...)
+	return nil
+}
+
+// LoadHookAllowlist reads hook ranges from a file in the format of GitHub's
+// /meta API, so `curl https://api.github.com/meta` output can be used as is.
+func LoadHookAllowlist(path string) ([]netip.Prefix, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read hook allowlist: %w", err)
+	}
+	var meta gh.Meta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("decode hook allowlist %s: %w", path, err)
+	}
+	return hookPrefixes(meta)
+}
+
+// FetchHookAllowlist fetches hook ranges from a /meta endpoint.
+func FetchHookAllowlist(ctx context.Context, url string) ([]netip.Prefix, error) {
+	meta, err := gh.FetchMeta(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return hookPrefixes(*meta)
+}
+
+func hookPrefixes(meta gh.Meta) ([]netip.Prefix, error) {
+	prefixes, err := ParsePrefixes(meta.Hooks)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefixes) == 0 {
+		return nil, errors.New("no hook ranges listed")
+	}
+	return prefixes, nil
+}
+
+// refreshHookAllowlist refetches the hook ranges every interval until ctx is
+// cancelled. Failures keep the current ranges.
+func (h *Handler) refreshHookAllowlist(ctx context.Context) {
+	ticker := time.NewTicker(h.hookRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		prefixes, err := FetchHookAllowlist(fetchCtx, h.hookURL)
+		cancel()
+		if err == nil {
+			err = h.SetHookAllowlist(prefixes)
+		}
+		if err != nil {
+			metrics.HookAllowlistRefreshFailures.Inc()
+			h.log.Error("Failed to refresh hook allowlist, keeping current ranges", "url", h.hookURL, "error", err)
+			continue
+		}
+		h.log.Debug("Refreshed hook allowlist", "url", h.hookURL, "ranges", len(prefixes))
+	}
+}
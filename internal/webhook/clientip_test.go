@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/metrics"
+)
+
+func mustPrefixes(t *testing.T, list ...string) []netip.Prefix {
+	t.Helper()
+	p, err := ParsePrefixes(list)
+	if err != nil {
+		t.Fatalf("ParsePrefixes: %v", err)
+	}
+	return p
+}
+
+func TestClientIP(t *testing.T) {
+	h := NewHandler(Config{
+		WebhookSecret:  []byte(testSecret),
+		TrustedProxies: mustPrefixes(t, "127.0.0.1", "10.0.0.0/8"),
+	})
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"direct client ignores header", "203.0.113.9:4000", []string{"1.2.3.4"}, "203.0.113.9"},
+		{"trusted proxy", "127.0.0.1:5000", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"spoofed leftmost entry", "127.0.0.1:5000", []string{"1.2.3.4, 198.51.100.7"}, "198.51.100.7"},
+		{"several trusted hops", "127.0.0.1:5000", []string{"198.51.100.7, 10.1.2.3", "10.4.5.6"}, "198.51.100.7"},
+		{"garbage stops at last trusted hop", "127.0.0.1:5000", []string{"198.51.100.7, nonsense, 10.1.2.3"}, "10.1.2.3"},
+		{"only trusted hops", "127.0.0.1:5000", []string{"10.1.2.3"}, "10.1.2.3"},
+		{"no header", "127.0.0.1:5000", nil, "127.0.0.1"},
+		{"IPv6 client", "127.0.0.1:5000", []string{"2001:db8::1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := h.clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePrefixesRejectsGarbage(t *testing.T) {
+	if _, err := ParsePrefixes([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+	if _, err := ParsePrefixes([]string{"localhost"}); err == nil {
+		t.Error("expected error for host name")
+	}
+}
+
+func TestHookAllowlistRejectsBeforeSignature(t *testing.T) {
+	h := NewHandler(Config{
+		WebhookSecret: []byte(testSecret),
+		HookAllowlist: mustPrefixes(t, "192.30.252.0/22"),
+	})
+	rejected := testutil.ToFloat64(metrics.SourceRejected)
+	sigFailures := testutil.ToFloat64(metrics.SignatureFailures)
+
+	r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{}`))
+	r.RemoteAddr = "203.0.113.9:4000"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 from outside allowlist, got %d", w.Code)
+	}
+	if testutil.ToFloat64(metrics.SourceRejected) != rejected+1 || testutil.ToFloat64(metrics.SignatureFailures) != sigFailures {
+		t.Error("expected source rejection counted without a signature check")
+	}
+
+	// httptest requests come from 192.0.2.1
+	if err := h.SetHookAllowlist(mustPrefixes(t, "192.0.2.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	if w := postQueued(h, 1, "guid-allowed-source"); w.Code != http.StatusAccepted {
+		t.Errorf("expected delivery from allowlisted range accepted, got %d", w.Code)
+	}
+}
+
+func TestHookAllowlistFromMeta(t *testing.T) {
+	meta := `{"hooks": ["192.30.252.0/22", "2a0a:a440::/29"], "web": ["140.82.112.0/20"]}`
+	path := filepath.Join(t.TempDir(), "meta.json")
+	if err := os.WriteFile(path, []byte(meta), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadHookAllowlist(path)
+	if err != nil || len(loaded) != 2 {
+		t.Fatalf("LoadHookAllowlist = %v, %v", loaded, err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(meta))
+	}))
+	defer server.Close()
+	fetched, err := FetchHookAllowlist(context.Background(), server.URL)
+	if err != nil || len(fetched) != 2 || fetched[0] != loaded[0] {
+		t.Fatalf("FetchHookAllowlist = %v, %v", fetched, err)
+	}
+
+	if _, err := hookPrefixes(gh.Meta{}); err == nil {
+		t.Error("expected error for meta without hook ranges")
+	}
+}
+
+func TestRefreshHookAllowlistKeepsRangesOnFailure(t *testing.T) {
+	fail := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-fail:
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			_, _ = w.Write([]byte(`{"hooks": ["198.51.100.0/24"]}`))
+		}
+	}))
+	defer server.Close()
+
+	h := NewHandler(Config{
+		WebhookSecret:        []byte(testSecret),
+		HookAllowlist:        mustPrefixes(t, "192.30.252.0/22"),
+		HookAllowlistURL:     server.URL,
+		HookAllowlistRefresh: 10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.refreshHookAllowlist(ctx)
+
+	refreshed := netip.MustParseAddr("198.51.100.7")
+	deadline := time.Now().Add(2 * time.Second)
+	for !h.sourceAllowed(refreshed) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected ranges refreshed from meta endpoint")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(fail)
+	time.Sleep(50 * time.Millisecond)
+	if !h.sourceAllowed(refreshed) {
+		t.Error("expected failed refresh to keep current ranges")
+	}
+}
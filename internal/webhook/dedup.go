@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+)
+
+// deliveryCache remembers recently processed X-GitHub-Delivery GUIDs.
+type deliveryCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	ttl       time.Duration
+	lastPrune time.Time
+}
+
+func newDeliveryCache(ttl time.Duration) *deliveryCache {
+	return &deliveryCache{
+		seen: make(map[string]time.Time),
+		ttl:  ttl,
+	}
+}
+
+// claim records id and reports whether it was new. Empty IDs are never
+// treated as duplicates.
+func (c *deliveryCache) claim(id string) bool {
+	if id == "" {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.pruneLocked(now)
+
+	if at, ok := c.seen[id]; ok && now.Sub(at) < c.ttl {
+		return false
+	}
+	c.seen[id] = now
+	return true
+}
+
+// forget drops id so a redelivery after a failed attempt is processed.
+func (c *deliveryCache) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.seen, id)
+}
+
+// pruneLocked drops expired entries at most once per TTL/2 to bound map growth.
+func (c *deliveryCache) pruneLocked(now time.Time) {
+	if now.Sub(c.lastPrune) < c.ttl/2 {
+		return
+	}
+	c.lastPrune = now
+	for id, at := range c.seen {
+		if now.Sub(at) >= c.ttl {
+			delete(c.seen, id)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeliveryCacheClaim(t *testing.T) {
+	c := newDeliveryCache(time.Hour)
+
+	if !c.claim("abc") {
+		t.Error("first delivery should be new")
+	}
+	if c.claim("abc") {
+		t.Error("repeated delivery should be a duplicate")
+	}
+	if !c.claim("def") {
+		t.Error("different delivery should be new")
+	}
+	if !c.claim("") || !c.claim("") {
+		t.Error("deliveries without an ID are never duplicates")
+	}
+}
+
+func TestDeliveryCacheForget(t *testing.T) {
+	c := newDeliveryCache(time.Hour)
+	c.claim("abc")
+	c.forget("abc")
+
+	if !c.claim("abc") {
+		t.Error("forgotten delivery should be processed again")
+	}
+}
+
+func TestDeliveryCacheExpiry(t *testing.T) {
+	c := newDeliveryCache(50 * time.Millisecond)
+	c.claim("abc")
+	c.claim("def")
+
+	time.Sleep(60 * time.Millisecond)
+
+	if !c.claim("abc") {
+		t.Error("delivery should be accepted again after TTL")
+	}
+	if _, ok := c.seen["def"]; ok {
+		t.Error("expired entries should be pruned")
+	}
+}
@@ -2,22 +2,44 @@ package webhook
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/thomasvincent/github-runners-infra/internal/digitalocean"
+	"github.com/thomasvincent/github-runners-infra/internal/cleanup"
 	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/metrics"
+	"github.com/thomasvincent/github-runners-infra/internal/pool"
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+	"github.com/thomasvincent/github-runners-infra/internal/state"
+	"github.com/thomasvincent/github-runners-infra/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const maxBodySize = 1 * 1024 * 1024 // 1 MB (#3)
 
+// DefaultRunnerDownloadURL is where runners download the actions/runner
+// release. GitHub Enterprise Server runners use it too unless mirrored.
+const DefaultRunnerDownloadURL = "https://github.com/actions/runner/releases/download"
+
+// DefaultRunnerVersion is the actions/runner release runners install unless
+// configured otherwise.
+const DefaultRunnerVersion = "2.331.0"
+
+var tracer = otel.Tracer("github.com/thomasvincent/github-runners-infra/internal/webhook")
+
 // Input validation regexes (#9)
 var (
 	safeNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
@@ -26,22 +48,38 @@ var (
 
 // WorkflowJobEvent represents the GitHub workflow_job webhook payload.
 type WorkflowJobEvent struct {
-	Action      string      `json:"action"`
-	WorkflowJob WorkflowJob `json:"workflow_job"`
-	Org         *OrgInfo    `json:"organization,omitempty"`
-	Repo        RepoInfo    `json:"repository"`
+	Action       string            `json:"action"`
+	WorkflowJob  WorkflowJob       `json:"workflow_job"`
+	Org          *OrgInfo          `json:"organization,omitempty"`
+	Repo         RepoInfo          `json:"repository"`
+	Installation *InstallationInfo `json:"installation,omitempty"`
+}
+
+// InstallationID returns the app installation the event was delivered for,
+// or 0 if the payload names none.
+func (e WorkflowJobEvent) InstallationID() int64 {
+	if e.Installation == nil {
+		return 0
+	}
+	return e.Installation.ID
 }
 
 type WorkflowJob struct {
-	ID     int64    `json:"id"`
-	Name   string   `json:"name"`
-	Labels []string `json:"labels"`
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Labels     []string `json:"labels"`
+	RunnerName string   `json:"runner_name,omitempty"` // set once a runner picks up the job
+	Conclusion string   `json:"conclusion,omitempty"`
 }
 
 type OrgInfo struct {
 	Login string `json:"login"`
 }
 
+type InstallationInfo struct {
+	ID int64 `json:"id"`
+}
+
 type RepoInfo struct {
 	FullName string `json:"full_name"`
 	Name     string `json:"name"`
@@ -52,26 +90,75 @@ type RepoInfo struct {
 
 // Handler processes incoming GitHub webhooks.
 type Handler struct {
-	webhookSecret []byte
 	githubApp     *gh.App
-	doClient      *digitalocean.Client
-	doToken       string
-	requiredLabel string
+	installations map[int64]bool // allowed installation IDs, nil allows all
+	provider      provider.Provider
+	callbackURL   string // public base URL runners call to self-destruct
+	callbackKey   []byte // HMAC key for self-destruct tokens
+	traceURL      string // OTLP/HTTP endpoint runners report boot spans to
+	pools         pool.Set
 	runnerVersion string
-	workerPool    chan struct{}     // concurrency limiter (#8)
+	downloadURL   string           // base URL of actions/runner release downloads
+	workers       int              // concurrent provisioning workers (#8)
 	rateLimiter   *repoRateLimiter // per-repo rate limiter (#7)
+	store         *state.Store     // job and droplet lifecycle, doubles as the provisioning queue
+	log           *slog.Logger
+	retry         retryPolicy
+	wake          chan struct{} // nudges an idle worker when a job is queued
+	deliveries    *deliveryCache
+	warm          *warmPool
+	reconciler    *reconciler
+	excludeTag    string // instances under debugging, never deleted
+
+	trustedProxies []netip.Prefix // proxies whose X-Forwarded-For entries are believed
+	hookURL        string         // /meta endpoint the hook allowlist is refreshed from
+	hookRefresh    time.Duration
+
+	hooksMu    sync.RWMutex
+	hookRanges []netip.Prefix // ranges deliveries are accepted from, nil accepts any
+
+	secretsMu      sync.RWMutex
+	webhookSecrets []gh.WebhookSecret // accepted delivery signing secrets, see SetWebhookSecrets
+
+	// background tracks droplet deletions started by deliveries; Run waits
+	// for them before returning.
+	background sync.WaitGroup
+
+	// provision is provisionRunner; replaced in tests.
+	provision func(ctx context.Context, job state.Job) error
 }
 
 // Config holds handler configuration.
 type Config struct {
-	WebhookSecret    []byte
-	GitHubApp        *gh.App
-	DOClient         *digitalocean.Client
-	DOToken          string
-	RequiredLabel    string
-	RunnerVersion    string
-	MaxConcurrent    int
-	MaxPerRepoPerMin int
+	WebhookSecret     []byte             // single secret, used when WebhookSecrets is empty
+	WebhookSecrets    []gh.WebhookSecret // accepted secrets while rotating, current first
+	GitHubApp         *gh.App
+	Installations     []int64           // app installations to serve; empty serves every installation
+	Provider          provider.Provider // launches runner instances, e.g. *digitalocean.Client
+	CallbackURL       string            // public base URL of this server, e.g. https://runners.example.com
+	CallbackSecret    []byte            // signs self-destruct tokens; random per process if empty
+	RunnerTraceURL    string            // OTLP/HTTP endpoint reachable from runners; empty disables boot spans
+	RequiredLabel     string            // label of the default pool when Pools is empty
+	Pools             pool.Set          // label-matched droplet configurations
+	RunnerVersion     string
+	RunnerDownloadURL string // base URL of actions/runner releases; defaults to github.com, mirrors serve air-gapped GHES
+	MaxConcurrent     int
+	MaxPerRepoPerMin  int
+	MaxAttempts       int           // provisioning attempts per job before giving up
+	RetryBaseDelay    time.Duration // first retry delay, doubled on each attempt
+	DedupTTL          time.Duration // how long delivery GUIDs are remembered
+	WarmMaxHourlyCost float64       // cap on the hourly price of idle warm runners, 0 = no cap
+	ReconcileInterval time.Duration // how often droplets are cross-checked against GitHub runners, 0 disables
+	ReconcileGrace    time.Duration // how long a droplet may boot, or a runner be orphaned, before reconciliation acts
+	ExcludeTag        string        // instances carrying this tag are never deleted; defaults to the cleanup policy's
+	Store             *state.Store  // defaults to an in-memory store
+	Logger            *slog.Logger  // defaults to slog.Default()
+
+	// Source checks on webhook deliveries
+	TrustedProxies       []netip.Prefix // reverse proxies in front of the listener, e.g. Caddy on localhost
+	HookAllowlist        []netip.Prefix // source ranges deliveries are accepted from; empty accepts any
+	HookAllowlistURL     string         // /meta endpoint HookAllowlist is refreshed from, empty disables
+	HookAllowlistRefresh time.Duration  // how often HookAllowlistURL is fetched, default 1h
 }
 
 // repoRateLimiter implements a simple per-repo token bucket. (#7)
@@ -125,9 +212,17 @@ func NewHandler(cfg Config) *Handler {
 	if label == "" {
 		label = "self-hosted"
 	}
+	pools := cfg.Pools
+	if len(pools) == 0 {
+		pools = pool.Set{{Name: "default", Labels: []string{label}}}
+	}
 	version := cfg.RunnerVersion
 	if version == "" {
-		version = "2.331.0"
+		version = DefaultRunnerVersion
+	}
+	downloadURL := cfg.RunnerDownloadURL
+	if downloadURL == "" {
+		downloadURL = DefaultRunnerDownloadURL
 	}
 	maxConcurrent := cfg.MaxConcurrent
 	if maxConcurrent <= 0 {
@@ -138,25 +233,125 @@ func NewHandler(cfg Config) *Handler {
 		maxPerRepo = 20
 	}
 
-	return &Handler{
-		webhookSecret: cfg.WebhookSecret,
+	store := cfg.Store
+	if store == nil {
+		store = state.NewMemory()
+	}
+	retry := retryPolicy{
+		maxAttempts: cfg.MaxAttempts,
+		baseDelay:   cfg.RetryBaseDelay,
+		maxDelay:    5 * time.Minute,
+	}
+	if retry.maxAttempts <= 0 {
+		retry.maxAttempts = 6
+	}
+	if retry.baseDelay <= 0 {
+		retry.baseDelay = 5 * time.Second
+	}
+
+	dedupTTL := cfg.DedupTTL
+	if dedupTTL <= 0 {
+		dedupTTL = time.Hour
+	}
+
+	secrets := cfg.WebhookSecrets
+	if len(secrets) == 0 {
+		secrets = []gh.WebhookSecret{{ID: defaultSecretID, Secret: string(cfg.WebhookSecret)}}
+	}
+
+	// Self-destruct tokens never share the webhook secret. Without a
+	// CallbackSecret they are signed with a random key, so they do not
+	// survive a restart.
+	callbackKey := cfg.CallbackSecret
+	if len(callbackKey) == 0 {
+		callbackKey = make([]byte, 32)
+		_, _ = rand.Read(callbackKey)
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	excludeTag := cfg.ExcludeTag
+	if excludeTag == "" {
+		excludeTag = cleanup.DefaultExcludeTag
+	}
+
+	hookRefresh := cfg.HookAllowlistRefresh
+	if hookRefresh <= 0 {
+		hookRefresh = time.Hour
+	}
+	var hookRanges []netip.Prefix
+	if len(cfg.HookAllowlist) > 0 {
+		hookRanges = cfg.HookAllowlist
+	}
+
+	var installations map[int64]bool
+	if len(cfg.Installations) > 0 {
+		installations = make(map[int64]bool, len(cfg.Installations))
+		for _, id := range cfg.Installations {
+			installations[id] = true
+		}
+	}
+
+	h := &Handler{
 		githubApp:     cfg.GitHubApp,
-		doClient:      cfg.DOClient,
-		doToken:       cfg.DOToken,
-		requiredLabel: label,
+		installations: installations,
+		provider:      cfg.Provider,
+		callbackURL:   strings.TrimSuffix(cfg.CallbackURL, "/"),
+		callbackKey:   callbackKey,
+		traceURL:      strings.TrimSuffix(cfg.RunnerTraceURL, "/"),
+		pools:         pools,
 		runnerVersion: version,
-		workerPool:    make(chan struct{}, maxConcurrent),
+		downloadURL:   strings.TrimSuffix(downloadURL, "/"),
+		workers:       maxConcurrent,
 		rateLimiter:   newRepoRateLimiter(maxPerRepo),
+		store:         store,
+		log:           logger,
+		retry:         retry,
+		wake:          make(chan struct{}, 1),
+		deliveries:    newDeliveryCache(dedupTTL),
+		excludeTag:    excludeTag,
+
+		trustedProxies: cfg.TrustedProxies,
+		hookURL:        cfg.HookAllowlistURL,
+		hookRefresh:    hookRefresh,
+		hookRanges:     hookRanges,
+		webhookSecrets: secrets,
+	}
+	h.provision = h.provisionRunner
+	h.warm = newWarmPool(h, cfg.WarmMaxHourlyCost)
+
+	grace := cfg.ReconcileGrace
+	if grace <= 0 {
+		grace = 15 * time.Minute
 	}
+	h.reconciler = newReconciler(h, cfg.ReconcileInterval, grace)
+	return h
 }
 
 // ServeHTTP handles webhook requests.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "webhook.ServeHTTP", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	// Only GitHub's hook ranges may deliver, if configured; checked before
+	// the body is read or the signature computed.
+	clientAddr := h.clientAddr(r)
+	clientIP := h.clientIP(r)
+	if !h.sourceAllowed(clientAddr) {
+		h.log.Warn("Rejecting delivery from outside hook allowlist", "security", true, "client_ip", clientIP)
+		metrics.SourceRejected.Inc()
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
 	// Limit request body size (#3)
 	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
 	body, err := io.ReadAll(r.Body)
@@ -165,130 +360,462 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	clientIP := r.Header.Get("X-Forwarded-For")
-	if clientIP == "" {
-		clientIP = r.RemoteAddr
-	}
-
 	sig := r.Header.Get("X-Hub-Signature-256")
-	if !gh.VerifyWebhookSignature(body, sig, h.webhookSecret, clientIP) {
+	secret, err := gh.MatchWebhookSignature(body, sig, h.secrets(), time.Now())
+	if err != nil {
+		if errors.Is(err, gh.ErrSecretExpired) {
+			h.log.Warn("Webhook signed with expired secret", "security", true, "client_ip", clientIP, "webhook_secret", secret.ID, "expired", secret.Expires)
+		} else {
+			h.log.Warn("Rejected webhook signature", "security", true, "client_ip", clientIP, "error", err)
+		}
+		metrics.SignatureFailures.Inc()
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
+	secretID := secret.ID
+	metrics.SignatureMatches.WithLabelValues(secretID).Inc()
 
 	eventType := r.Header.Get("X-GitHub-Event")
 	if eventType != "workflow_job" {
+		metrics.Deliveries.WithLabelValues(eventType, "").Inc()
 		w.WriteHeader(http.StatusOK)
 		_, _ = fmt.Fprint(w, "ok")
 		return
 	}
 
+	// Skip deliveries already handled; GitHub and the "Redeliver" button
+	// resend the same payload.
+	deliveryID := r.Header.Get("X-GitHub-Delivery")
+	logger := h.log.With("delivery_id", deliveryID, "webhook_secret", secretID)
+	span.SetAttributes(attribute.String("github.delivery_id", deliveryID))
+	if !h.deliveries.claim(deliveryID) {
+		logger.Info("Skipping duplicate delivery")
+		metrics.DuplicatesSkipped.WithLabelValues("delivery").Inc()
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, "duplicate")
+		return
+	}
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		// Let a redelivery through if this attempt failed.
+		if rec.status >= 300 {
+			h.deliveries.forget(deliveryID)
+		}
+	}()
+	w = rec
+
 	var event WorkflowJobEvent
 	if err := json.Unmarshal(body, &event); err != nil {
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
-
-	if event.Action != "queued" {
+	metrics.Deliveries.WithLabelValues(eventType, event.Action).Inc()
+	installation := event.InstallationID()
+	logger = logger.With("job_id", event.WorkflowJob.ID, "repo", event.Repo.FullName, "installation_id", installation)
+	span.SetAttributes(
+		attribute.String("github.action", event.Action),
+		attribute.Int64("github.job_id", event.WorkflowJob.ID),
+		attribute.String("github.repo", event.Repo.FullName),
+		attribute.Int64("github.installation_id", installation),
+	)
+
+	if !h.installationAllowed(installation) {
+		logger.Warn("Ignoring delivery for installation not in allowlist", "security", true, "client_ip", clientIP)
 		w.WriteHeader(http.StatusOK)
-		_, _ = fmt.Fprint(w, "ok")
+		_, _ = fmt.Fprint(w, "ignored")
 		return
 	}
 
-	if !h.hasRequiredLabel(event.WorkflowJob.Labels) {
+	// Only new jobs need a pool; later events tear down whatever was
+	// provisioned, even if the pools changed since.
+	switch event.Action {
+	case "queued":
+		p, ok := h.matchPool(event.WorkflowJob.Labels)
+		if !ok {
+			if hasLabel(event.WorkflowJob.Labels, "self-hosted") {
+				logger.Warn("Rejecting job: labels match no runner pool", "labels", event.WorkflowJob.Labels)
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = fmt.Fprint(w, "ok")
+			return
+		}
+		h.handleQueued(ctx, w, logger, event, p, clientIP)
+	case "in_progress":
+		h.handleInProgress(logger, event)
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, "ok")
+	case "completed":
+		h.handleCompleted(logger, event)
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, "ok")
+	default:
 		w.WriteHeader(http.StatusOK)
 		_, _ = fmt.Fprint(w, "ok")
-		return
 	}
+}
 
+// handleQueued queues a runner droplet from pool p for a newly queued job.
+func (h *Handler) handleQueued(ctx context.Context, w http.ResponseWriter, logger *slog.Logger, event WorkflowJobEvent, p *pool.Pool, clientIP string) {
 	// Rate limit per repo (#7)
 	repoKey := event.Repo.FullName
 	if !h.rateLimiter.allow(repoKey) {
-		log.Printf("SECURITY: rate limit exceeded for %s from %s", repoKey, clientIP)
+		logger.Warn("Rate limit exceeded", "security", true, "client_ip", clientIP)
+		metrics.RateLimited.Inc()
 		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
 		return
 	}
 
-	// Worker pool for bounded concurrency (#8)
-	select {
-	case h.workerPool <- struct{}{}:
-		go func() {
-			defer func() { <-h.workerPool }()
-			h.provisionRunner(event)
-		}()
+	// An idle warm runner will pick the job up; no droplet needed. The
+	// reservation is made first so the job is persisted as warm_reserved and
+	// no worker can claim it in between.
+	jobID := event.WorkflowJob.ID
+	reserved := h.warm.reserve(p, repoKey, jobID)
+	enqueue := h.store.Enqueue
+	if reserved {
+		enqueue = h.store.EnqueueReserved
+	}
+
+	// Persist before acknowledging so the job survives a restart; workers
+	// drain the queue at their own pace. (#8) The trace context goes along
+	// so provisioning shows up under this delivery.
+	created, err := enqueue(jobID, event.InstallationID(), repoKey, p.Name, event.WorkflowJob.Labels, tracing.Carrier(ctx))
+	if reserved && (err != nil || !created) {
+		h.warm.release(jobID)
+	}
+	if err != nil {
+		logger.Error("Failed to record job", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !created {
+		logger.Info("Skipping duplicate job: runner already provisioned or in flight")
+		metrics.DuplicatesSkipped.WithLabelValues("job").Inc()
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, "duplicate")
+		return
+	}
+
+	if reserved {
+		logger.Info("Job reserved an idle warm runner", "pool", p.Name)
 		w.WriteHeader(http.StatusAccepted)
-		_, _ = fmt.Fprint(w, "provisioning")
-	default:
-		log.Printf("WARN: worker pool full, rejecting job %d", event.WorkflowJob.ID)
-		http.Error(w, "system busy", http.StatusServiceUnavailable)
+		_, _ = fmt.Fprint(w, "queued")
+		return
 	}
+	logger.Info("Job queued", "pool", p.Name)
+	h.notifyWorkers()
+
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = fmt.Fprint(w, "queued")
+}
+
+// installationAllowed reports whether deliveries for installation id are
+// served. Without an allowlist every installation of the app is.
+func (h *Handler) installationAllowed(id int64) bool {
+	return h.installations == nil || h.installations[id]
+}
+
+// appInstallations returns the app's installations this handler serves.
+func (h *Handler) appInstallations(ctx context.Context) ([]gh.Installation, error) {
+	all, err := h.githubApp.ListInstallations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var served []gh.Installation
+	for _, inst := range all {
+		if h.installationAllowed(inst.ID) {
+			served = append(served, inst)
+		}
+	}
+	return served, nil
+}
+
+// statusRecorder captures the response status written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
 }
 
-func (h *Handler) hasRequiredLabel(labels []string) bool {
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// matchPool returns the most specific runner pool for the job's labels.
+func (h *Handler) matchPool(labels []string) (*pool.Pool, bool) {
+	return h.pools.Match(labels)
+}
+
+// poolForJob returns the pool a job was queued for. If the pool has since
+// been removed from the config, the job's labels are matched again.
+func (h *Handler) poolForJob(job state.Job) (*pool.Pool, bool) {
+	if p, ok := h.pools.Get(job.Pool); ok {
+		return p, true
+	}
+	return h.matchPool(job.Labels)
+}
+
+func hasLabel(labels []string, want string) bool {
 	for _, l := range labels {
-		if strings.EqualFold(l, h.requiredLabel) {
+		if strings.EqualFold(l, want) {
 			return true
 		}
 	}
 	return false
 }
 
-func (h *Handler) provisionRunner(event WorkflowJobEvent) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+// runnerSpec builds the instance configuration for a pool's runners.
+func runnerSpec(p *pool.Pool) provider.Spec {
+	return provider.Spec{
+		Region:    p.Region,
+		Size:      p.Size,
+		Image:     p.Image,
+		Tags:      append([]string{p.Tag()}, p.Tags...),
+		CloudInit: p.Template(),
+		TTL:       p.RunnerTTL(),
+	}
+}
+
+// jobSpec builds the instance configuration for a job's runner. A
+// timeout-<n>m job label overrides the pool's TTL, for jobs known to run long,
+// up to the pool's max_ttl.
+func jobSpec(p *pool.Pool, labels []string) provider.Spec {
+	spec := runnerSpec(p)
+	if ttl, ok := provider.TTLFromLabels(labels); ok {
+		limit := p.MaxRunnerTTL()
+		if limit == 0 {
+			limit = provider.DefaultTTL
+		}
+		spec.TTL = min(ttl, limit)
+	}
+	return spec
+}
+
+// provisionRunner creates a runner droplet for a claimed job. Errors wrapping
+// errInvalidJob are permanent; any other error is retried.
+func (h *Handler) provisionRunner(ctx context.Context, job state.Job) (err error) {
+	ctx, span := tracer.Start(tracing.Extract(ctx, job.Trace), "provisionRunner", trace.WithAttributes(
+		attribute.Int64("github.job_id", job.ID),
+		attribute.String("github.repo", job.Repo),
+		attribute.String("runner.pool", job.Pool),
+		attribute.Int("attempt", job.Attempts),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
+	if job.Installation != 0 {
+		ctx = gh.WithInstallation(ctx, job.Installation)
+	}
 
-	owner := event.Repo.Owner.Login
-	repo := event.Repo.Name
+	owner, repo, _ := strings.Cut(job.Repo, "/")
+
+	p, ok := h.poolForJob(job)
+	if !ok {
+		return fmt.Errorf("%w: labels %v match no runner pool", errInvalidJob, job.Labels)
+	}
 
 	// Validate inputs (#9)
 	if !safeNameRegex.MatchString(owner) || !safeNameRegex.MatchString(repo) {
-		log.Printf("ERROR: invalid owner/repo: %s/%s", owner, repo)
-		return
+		return fmt.Errorf("%w: owner/repo %q", errInvalidJob, job.Repo)
 	}
 
-	runnerToken, err := h.githubApp.GenerateRepoRunnerToken(owner, repo)
+	runnerName := fmt.Sprintf("%s%s-%d-%d", jobRunnerPrefix, repo, job.ID, time.Now().Unix())
+	if len(runnerName) > 63 {
+		runnerName = runnerName[:63]
+	}
+
+	span.SetAttributes(attribute.String("runner.name", runnerName))
+
+	droplet, err := h.launchRunner(ctx, p, jobSpec(p, job.Labels), owner, repo, runnerName, job.Labels)
 	if err != nil {
-		log.Printf("ERROR: runner token for %s/%s: %v", owner, repo, err)
-		return
+		return err
+	}
+	span.SetAttributes(attribute.Int("runner.instance_id", droplet.ID))
+	logger := h.jobLog(job).With("runner_name", runnerName, "droplet_id", droplet.ID)
+	if err := h.store.RecordDroplet(job.ID, runnerName, droplet.ID); err != nil {
+		logger.Error("Failed to record droplet", "error", err)
 	}
 
-	runnerName := fmt.Sprintf("eph-%s-%d-%d", repo, event.WorkflowJob.ID, time.Now().Unix())
-	if len(runnerName) > 63 {
-		runnerName = runnerName[:63]
+	logger.Info("Provisioned runner", "pool", p.Name)
+	return nil
+}
+
+// launchRunner registers runnerName with the given labels at pool p's scope
+// via a just-in-time config and starts a provider instance that runs it. ctx
+// selects the app installation to register with.
+func (h *Handler) launchRunner(ctx context.Context, p *pool.Pool, spec provider.Spec, owner, repo, runnerName string, labels []string) (provider.Instance, error) {
+	repoFull := fmt.Sprintf("%s/%s", owner, repo)
+	if !repoRegex.MatchString(repoFull) {
+		return provider.Instance{}, fmt.Errorf("%w: repo format %q", errInvalidJob, repoFull)
 	}
 
 	// Validate and sanitize labels (#9)
 	var safeLabels []string
-	for _, l := range event.WorkflowJob.Labels {
+	for _, l := range labels {
 		cleaned := strings.TrimSpace(l)
 		if safeNameRegex.MatchString(cleaned) {
 			safeLabels = append(safeLabels, cleaned)
 		}
 	}
-	labels := strings.Join(safeLabels, ",")
 
-	repoFull := fmt.Sprintf("%s/%s", owner, repo)
-	if !repoRegex.MatchString(repoFull) {
-		log.Printf("ERROR: invalid repo format: %s", repoFull)
-		return
+	jit, err := h.jitConfig(ctx, p, owner, repo, runnerName, safeLabels)
+	if err != nil {
+		return provider.Instance{}, fmt.Errorf("jit config for %s: %w", repoFull, err)
 	}
 
-	params := digitalocean.RunnerParams{
+	params := provider.RunnerParams{
 		RunnerName:    runnerName,
-		RunnerToken:   runnerToken,
-		RunnerLabels:  labels,
+		JITConfig:     jit.EncodedConfig,
 		RunnerOrg:     owner,
 		RunnerRepo:    repoFull,
-		DOToken:       h.doToken,
+		CallbackURL:   h.selfDestructURL(runnerName),
+		CallbackToken: h.selfDestructToken(runnerName),
 		RunnerVersion: h.runnerVersion,
+		TraceParent:   tracing.TraceParent(ctx),
+		TraceURL:      h.traceURL,
+		TTL:           spec.TTL,
+
+		GitHubURL:         h.githubApp.ServerURL(),
+		RunnerDownloadURL: h.downloadURL,
 	}
 
-	droplet, err := h.doClient.CreateRunner(ctx, params)
+	inst, err := h.provider.CreateRunner(ctx, spec, params)
 	if err != nil {
-		log.Printf("ERROR: create droplet for job %d: %v", event.WorkflowJob.ID, err)
+		metrics.ProviderErrors.WithLabelValues("create").Inc()
+		// The runner is already registered; drop it so it does not linger offline.
+		if rmErr := h.removeRunner(ctx, p, owner, repo, jit.Runner.ID); rmErr != nil {
+			h.log.Error("Failed to remove unused runner", "runner_name", runnerName, "repo", repoFull, "error", rmErr)
+		}
+		return provider.Instance{}, fmt.Errorf("create runner: %w", err)
+	}
+	return inst, nil
+}
+
+// jitConfig registers runnerName on owner/repo, or for org-scoped pools on
+// the owner org in the pool's runner group.
+func (h *Handler) jitConfig(ctx context.Context, p *pool.Pool, owner, repo, runnerName string, labels []string) (*gh.JITConfig, error) {
+	if !p.OrgScoped() {
+		return h.githubApp.GenerateRepoJITConfig(ctx, owner, repo, runnerName, labels)
+	}
+	groupID := int64(gh.DefaultRunnerGroupID)
+	if p.RunnerGroup != "" {
+		id, err := h.githubApp.RunnerGroupID(ctx, owner, p.RunnerGroup)
+		if err != nil {
+			return nil, err
+		}
+		groupID = id
+	}
+	return h.githubApp.GenerateOrgJITConfig(ctx, owner, runnerName, labels, groupID)
+}
+
+// removeRunner deregisters a runner created by jitConfig.
+func (h *Handler) removeRunner(ctx context.Context, p *pool.Pool, owner, repo string, runnerID int64) error {
+	if p.OrgScoped() {
+		return h.githubApp.RemoveOrgRunner(ctx, owner, runnerID)
+	}
+	return h.githubApp.RemoveRepoRunner(ctx, owner, repo, runnerID)
+}
+
+// handleInProgress records which job a provisioned runner picked up, so the
+// droplet is only released when that job completes.
+func (h *Handler) handleInProgress(logger *slog.Logger, event WorkflowJobEvent) {
+	job := event.WorkflowJob
+	if job.RunnerName == "" {
+		return
+	}
+	logger = logger.With("runner_name", job.RunnerName)
+	droplet, ours, err := h.store.AssignRunner(job.ID, event.Repo.FullName, job.RunnerName)
+	if err != nil {
+		logger.Error("Failed to record runner assignment", "error", err)
 		return
 	}
+	if !ours {
+		h.warm.release(job.ID)
+		return
+	}
+	logger.Info("Job started on runner", "droplet_id", droplet.ID)
+	if droplet.Warm {
+		h.warm.claimed(droplet.Pool, droplet.ID, job.ID)
+	} else {
+		h.warm.release(job.ID)
+	}
+}
+
+// handleCompleted tears down the droplet that ran (or was provisioned for) a
+// completed job instead of waiting for self-destruct or the cleanup timer.
+func (h *Handler) handleCompleted(logger *slog.Logger, event WorkflowJobEvent) {
+	job := event.WorkflowJob
+	droplet, ok, err := h.store.Complete(job.ID, job.RunnerName, job.Conclusion)
+	if err != nil {
+		logger.Error("Failed to record job completion", "error", err)
+		return
+	}
+	// A job cancelled while waiting on a warm runner no longer needs it.
+	h.warm.release(job.ID)
+	if !ok {
+		return
+	}
+	logger = logger.With("runner_name", droplet.RunnerName, "droplet_id", droplet.ID)
+
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		// A failed delete leaves the droplet "released" for cmd/cleanup to retry.
+		if excluded, err := h.excludedDroplet(ctx, droplet.ID); err != nil || excluded {
+			if err != nil {
+				metrics.ProviderErrors.WithLabelValues("describe").Inc()
+				logger.Error("Failed to look up droplet of completed job", "error", err)
+			} else {
+				logger.Info("Keeping excluded droplet of completed job", "tag", h.excludeTag)
+			}
+			return
+		}
+		if err := h.provider.DeleteRunner(ctx, droplet.ID); err != nil {
+			metrics.ProviderErrors.WithLabelValues("delete").Inc()
+			logger.Error("Failed to delete droplet of completed job", "error", err)
+			return
+		}
+		if err := h.store.MarkDropletDeleted(droplet.ID); err != nil {
+			logger.Error("Failed to record droplet deleted", "error", err)
+		}
+		logger.Info("Deleted droplet after job completed", "conclusion", job.Conclusion)
+	}()
+}
+
+// excluded reports whether inst carries the exclude tag and must be kept.
+func (h *Handler) excluded(inst provider.Instance) bool {
+	return inst.HasTag(h.excludeTag)
+}
+
+// excludedDroplet looks up a droplet and reports whether it carries the
+// exclude tag. A droplet that no longer exists is not excluded.
+func (h *Handler) excludedDroplet(ctx context.Context, id int) (bool, error) {
+	inst, err := h.provider.DescribeRunner(ctx, id)
+	if errors.Is(err, provider.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return h.excluded(inst), nil
+}
+
+func (h *Handler) failJob(jobID int64, reason string) {
+	if err := h.store.Transition(jobID, state.StatusFailed, reason); err != nil {
+		h.log.Error("Failed to record job failure", "job_id", jobID, "error", err)
+	}
+}
 
-	log.Printf("Provisioned runner %s (droplet %d) for %s job %d",
-		runnerName, droplet.ID, repoFull, event.WorkflowJob.ID)
+// jobLog returns a logger carrying a queued job's correlation fields.
+func (h *Handler) jobLog(job state.Job) *slog.Logger {
+	return h.log.With("job_id", job.ID, "repo", job.Repo, "installation_id", job.Installation)
 }
@@ -1,17 +1,32 @@
 package webhook
 
 import (
+	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/metrics"
+	"github.com/thomasvincent/github-runners-infra/internal/pool"
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+	"github.com/thomasvincent/github-runners-infra/internal/state"
+	"github.com/thomasvincent/github-runners-infra/internal/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 const testSecret = "test-webhook-secret"
@@ -43,6 +58,7 @@ func TestMethodNotAllowed(t *testing.T) {
 
 func TestInvalidSignature(t *testing.T) {
 	h := newTestHandler()
+	before := testutil.ToFloat64(metrics.SignatureFailures)
 	body := `{}`
 	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
 	req.Header.Set("X-Hub-Signature-256", "sha256=bad")
@@ -52,6 +68,21 @@ func TestInvalidSignature(t *testing.T) {
 	if w.Code != http.StatusUnauthorized {
 		t.Errorf("expected 401, got %d", w.Code)
 	}
+	if got := testutil.ToFloat64(metrics.SignatureFailures); got != before+1 {
+		t.Errorf("expected signature failure counted, got %v (before %v)", got, before)
+	}
+}
+
+func TestDeliveriesCountedByAction(t *testing.T) {
+	h := newTestHandler()
+	counter := metrics.Deliveries.WithLabelValues("workflow_job", "queued")
+	before := testutil.ToFloat64(counter)
+
+	postQueued(h, 1, "guid-metrics")
+
+	if got := testutil.ToFloat64(counter); got != before+1 {
+		t.Errorf("expected queued delivery counted, got %v (before %v)", got, before)
+	}
 }
 
 func TestNonWorkflowJobEvent(t *testing.T) {
@@ -93,6 +124,34 @@ func TestNonQueuedAction(t *testing.T) {
 	}
 }
 
+func TestInProgressUnknownRunner(t *testing.T) {
+	h := newTestHandler()
+	event := WorkflowJobEvent{
+		Action: "in_progress",
+		WorkflowJob: WorkflowJob{
+			ID:         1,
+			Labels:     []string{"self-hosted"},
+			RunnerName: "someone-elses-runner",
+		},
+		Repo: RepoInfo{FullName: "org/repo"},
+	}
+	body, _ := json.Marshal(event)
+	sig := signPayload(body, testSecret)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(string(body)))
+	req.Header.Set("X-Hub-Signature-256", sig)
+	req.Header.Set("X-GitHub-Event", "workflow_job")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 for in_progress event, got %d", w.Code)
+	}
+	if _, ok, _ := h.store.Job(1); ok {
+		t.Error("job on a runner we did not provision should not be recorded")
+	}
+}
+
 func TestMissingRequiredLabel(t *testing.T) {
 	h := newTestHandler()
 	event := WorkflowJobEvent{
@@ -116,7 +175,7 @@ func TestMissingRequiredLabel(t *testing.T) {
 	}
 }
 
-func TestHasRequiredLabel(t *testing.T) {
+func TestMatchPoolDefault(t *testing.T) {
 	h := newTestHandler()
 	tests := []struct {
 		labels []string
@@ -131,13 +190,123 @@ func TestHasRequiredLabel(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		got := h.hasRequiredLabel(tt.labels)
+		_, got := h.matchPool(tt.labels)
 		if got != tt.want {
-			t.Errorf("hasRequiredLabel(%v) = %v, want %v", tt.labels, got, tt.want)
+			t.Errorf("matchPool(%v) = %v, want %v", tt.labels, got, tt.want)
 		}
 	}
 }
 
+func TestMatchPoolConfigured(t *testing.T) {
+	h := NewHandler(Config{
+		WebhookSecret: []byte(testSecret),
+		Pools: pool.Set{
+			{Name: "small", Labels: []string{"self-hosted"}},
+			{Name: "chef", Labels: []string{"self-hosted", "chef"}, Size: "s-8vcpu-16gb"},
+		},
+	})
+
+	p, ok := h.matchPool([]string{"self-hosted", "chef"})
+	if !ok || p.Name != "chef" {
+		t.Errorf("expected chef pool, got %+v", p)
+	}
+	p, ok = h.matchPool([]string{"self-hosted", "lint"})
+	if !ok || p.Name != "small" {
+		t.Errorf("expected small pool, got %+v", p)
+	}
+
+	spec := runnerSpec(p)
+	if len(spec.Tags) == 0 || spec.Tags[0] != "pool:small" {
+		t.Errorf("expected pool tag on droplet spec, got %v", spec.Tags)
+	}
+}
+
+func TestUnmatchedJobNotQueued(t *testing.T) {
+	h := NewHandler(Config{
+		WebhookSecret: []byte(testSecret),
+		Pools:         pool.Set{{Name: "chef", Labels: []string{"self-hosted", "chef"}}},
+	})
+
+	// Carries self-hosted but no pool matches.
+	if w := postQueued(h, 1, "guid-1"); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for unmatched job, got %d", w.Code)
+	}
+	if _, ok, _ := h.store.Job(1); ok {
+		t.Error("unmatched job should not be queued")
+	}
+}
+
+func TestQueuedJobRecordsPool(t *testing.T) {
+	h := newTestHandler()
+	postQueued(h, 1, "guid-1")
+
+	job, _, _ := h.store.Job(1)
+	if job.Pool != "default" {
+		t.Errorf("expected job queued for default pool, got %q", job.Pool)
+	}
+}
+
+func TestQueuedJobRecordsInstallation(t *testing.T) {
+	h := newTestHandler()
+	postEvent(h, WorkflowJobEvent{
+		Action:       "queued",
+		WorkflowJob:  WorkflowJob{ID: 1, Labels: []string{"self-hosted"}},
+		Repo:         RepoInfo{FullName: "other-org/repo"},
+		Installation: &InstallationInfo{ID: 77},
+	}, "guid-installation")
+
+	job, _, _ := h.store.Job(1)
+	if job.Installation != 77 {
+		t.Errorf("expected job to record installation 77, got %d", job.Installation)
+	}
+}
+
+func TestInstallationAllowlist(t *testing.T) {
+	h := NewHandler(Config{
+		WebhookSecret: []byte(testSecret),
+		Installations: []int64{77},
+	})
+	event := WorkflowJobEvent{
+		Action:       "queued",
+		WorkflowJob:  WorkflowJob{ID: 1, Labels: []string{"self-hosted"}},
+		Repo:         RepoInfo{FullName: "org/repo"},
+		Installation: &InstallationInfo{ID: 99},
+	}
+
+	w := postEvent(h, event, "guid-denied")
+	if w.Code != http.StatusOK || w.Body.String() != "ignored" {
+		t.Errorf("expected delivery for unlisted installation ignored, got %d %q", w.Code, w.Body.String())
+	}
+	if _, ok, _ := h.store.Job(1); ok {
+		t.Error("expected no job queued for unlisted installation")
+	}
+
+	event.Installation.ID = 77
+	if w := postEvent(h, event, "guid-allowed"); w.Code != http.StatusAccepted {
+		t.Errorf("expected listed installation queued, got %d", w.Code)
+	}
+}
+
+func TestQueuedJobLogsCorrelationFields(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandler(Config{
+		WebhookSecret: []byte(testSecret),
+		Logger:        slog.New(slog.NewJSONHandler(&buf, nil)),
+	})
+	postQueued(h, 42, "guid-42")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected one JSON log line, got %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "Job queued" || line["level"] != "INFO" {
+		t.Errorf("unexpected log line %v", line)
+	}
+	if line["job_id"] != float64(42) || line["repo"] != "org/repo" || line["delivery_id"] != "guid-42" {
+		t.Errorf("missing correlation fields in %v", line)
+	}
+}
+
 func TestRateLimiter(t *testing.T) {
 	rl := newRepoRateLimiter(3)
 	repo := "org/repo"
@@ -241,7 +410,7 @@ func TestSignatureVerification(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := gh.VerifyWebhookSignature(payload, tt.signature, secret, "127.0.0.1")
+			got := gh.VerifyWebhookSignature(payload, tt.signature, secret)
 			if got != tt.want {
 				t.Errorf("VerifyWebhookSignature() = %v, want %v", got, tt.want)
 			}
@@ -276,53 +445,295 @@ func TestInputValidationRegexes(t *testing.T) {
 	}
 }
 
-func TestWorkerPoolFull(t *testing.T) {
+func TestQueuedJobAcknowledgedRegardlessOfBacklog(t *testing.T) {
 	h := NewHandler(Config{
 		WebhookSecret: []byte(testSecret),
 		MaxConcurrent: 1,
 	})
 
-	// Fill the worker pool
-	h.workerPool <- struct{}{}
+	// No workers are running, so every job stays in the queue.
+	for id := int64(1); id <= 3; id++ {
+		event := WorkflowJobEvent{
+			Action: "queued",
+			WorkflowJob: WorkflowJob{
+				ID:     id,
+				Labels: []string{"self-hosted"},
+			},
+			Repo: RepoInfo{FullName: "org/repo"},
+		}
+		body, _ := json.Marshal(event)
+		sig := signPayload(body, testSecret)
+
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(string(body)))
+		req.Header.Set("X-Hub-Signature-256", sig)
+		req.Header.Set("X-GitHub-Event", "workflow_job")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusAccepted {
+			t.Errorf("job %d: expected 202, got %d", id, w.Code)
+		}
+		job, ok, _ := h.store.Job(id)
+		if !ok || job.Status != state.StatusQueued {
+			t.Errorf("expected job %d recorded as queued, got %+v (found=%v)", id, job, ok)
+		}
+	}
+}
 
+func postQueued(h *Handler, jobID int64, deliveryID string) *httptest.ResponseRecorder {
 	event := WorkflowJobEvent{
 		Action: "queued",
 		WorkflowJob: WorkflowJob{
-			ID:     1,
+			ID:     jobID,
 			Labels: []string{"self-hosted"},
 		},
 		Repo: RepoInfo{FullName: "org/repo"},
 	}
+	return postEvent(h, event, deliveryID)
+}
+
+func TestCompletedJobDeletesDroplet(t *testing.T) {
+	h := newTestHandler()
+	fake := newFakeProvider()
+	h.provider = fake
+	postQueued(h, 1, "guid-1")
+	_ = h.store.RecordDroplet(1, "eph-repo-1-100", 1001)
+
+	event := WorkflowJobEvent{
+		Action:      "completed",
+		WorkflowJob: WorkflowJob{ID: 1, Labels: []string{"self-hosted"}, RunnerName: "eph-repo-1-100", Conclusion: "success"},
+		Repo:        RepoInfo{FullName: "org/repo"},
+	}
+	if w := postEvent(h, event, "guid-2"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	// The droplet is deleted in the background.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if d, _, _ := h.store.Droplet(1001); d.Status == state.DropletDeleted {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if deleted := fake.deletedIDs(); len(deleted) != 1 || deleted[0] != 1001 {
+		t.Errorf("expected droplet 1001 deleted, got %v", deleted)
+	}
+	if d, _, _ := h.store.Droplet(1001); d.Status != state.DropletDeleted {
+		t.Errorf("expected droplet recorded deleted, got %s", d.Status)
+	}
+	if job, _, _ := h.store.Job(1); job.Status != state.StatusCompleted {
+		t.Errorf("expected job completed, got %s", job.Status)
+	}
+}
+
+func TestCompletedJobKeepsExcludedDroplet(t *testing.T) {
+	h := newTestHandler()
+	fake := newFakeProvider()
+	h.provider = fake
+	fake.instances[1001] = provider.Instance{ID: 1001, Name: "eph-repo-1-100", Tags: []string{runnerTag, "debug"}}
+	postQueued(h, 1, "guid-1")
+	_ = h.store.RecordDroplet(1, "eph-repo-1-100", 1001)
+
+	event := WorkflowJobEvent{
+		Action:      "completed",
+		WorkflowJob: WorkflowJob{ID: 1, Labels: []string{"self-hosted"}, RunnerName: "eph-repo-1-100", Conclusion: "success"},
+		Repo:        RepoInfo{FullName: "org/repo"},
+	}
+	if w := postEvent(h, event, "guid-2"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if deleted := fake.deletedIDs(); len(deleted) != 0 {
+		t.Errorf("expected droplet tagged debug kept, got %v deleted", deleted)
+	}
+	if d, _, _ := h.store.Droplet(1001); d.Status != state.DropletReleased {
+		t.Errorf("expected droplet left released, got %s", d.Status)
+	}
+}
+
+func TestCompletedJobTornDownAfterPoolRemoved(t *testing.T) {
+	h := newTestHandler()
+	fake := newFakeProvider()
+	h.provider = fake
+	_, _ = h.store.Enqueue(1, 0, "org/repo", "gpu", []string{"gpu"}, nil)
+	_ = h.store.RecordDroplet(1, "eph-repo-1-100", 1001)
+
+	// The gpu pool was dropped from the pools file since the job was queued.
+	event := WorkflowJobEvent{
+		Action:      "completed",
+		WorkflowJob: WorkflowJob{ID: 1, Labels: []string{"gpu"}, RunnerName: "eph-repo-1-100"},
+		Repo:        RepoInfo{FullName: "org/repo"},
+	}
+	if _, ok := h.matchPool(event.WorkflowJob.Labels); ok {
+		t.Fatal("test needs labels that match no pool")
+	}
+	postEvent(h, event, "guid-1")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(fake.deletedIDs()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if deleted := fake.deletedIDs(); len(deleted) != 1 || deleted[0] != 1001 {
+		t.Errorf("expected droplet 1001 deleted, got %v", deleted)
+	}
+}
+
+func postEvent(h *Handler, event WorkflowJobEvent, deliveryID string) *httptest.ResponseRecorder {
 	body, _ := json.Marshal(event)
 	sig := signPayload(body, testSecret)
 
 	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(string(body)))
 	req.Header.Set("X-Hub-Signature-256", sig)
 	req.Header.Set("X-GitHub-Event", "workflow_job")
+	req.Header.Set("X-GitHub-Delivery", deliveryID)
 	w := httptest.NewRecorder()
 	h.ServeHTTP(w, req)
+	return w
+}
+
+func duplicateCount(key string) float64 {
+	return testutil.ToFloat64(metrics.DuplicatesSkipped.WithLabelValues(key))
+}
+
+func TestDuplicateDeliverySkipped(t *testing.T) {
+	h := newTestHandler()
+	before := duplicateCount("delivery")
+
+	if w := postQueued(h, 1, "guid-1"); w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for first delivery, got %d", w.Code)
+	}
+	w := postQueued(h, 1, "guid-1")
+	if w.Code != http.StatusOK || w.Body.String() != "duplicate" {
+		t.Errorf("expected duplicate delivery to be skipped, got %d %q", w.Code, w.Body.String())
+	}
+	if got := duplicateCount("delivery"); got != before+1 {
+		t.Errorf("expected delivery duplicate counter %v, got %v", before+1, got)
+	}
+}
+
+func TestDuplicateJobSkipped(t *testing.T) {
+	h := newTestHandler()
 
-	if w.Code != http.StatusServiceUnavailable {
-		t.Errorf("expected 503 when worker pool full, got %d", w.Code)
+	postQueued(h, 1, "guid-1")
+	// Redeliver from the UI: new delivery GUID, same job.
+	w := postQueued(h, 1, "guid-2")
+	if w.Code != http.StatusOK || w.Body.String() != "duplicate" {
+		t.Errorf("expected duplicate job to be skipped, got %d %q", w.Code, w.Body.String())
 	}
 
-	// Drain the pool
-	<-h.workerPool
+	job, _, _ := h.store.Job(1)
+	if len(job.History) != 1 {
+		t.Errorf("duplicate should not touch the job timeline, got %+v", job.History)
+	}
+}
+
+func TestFailedJobRequeuedOnRedelivery(t *testing.T) {
+	h := newTestHandler()
+
+	postQueued(h, 1, "guid-1")
+	h.failJob(1, "create droplet: quota exceeded")
+
+	if w := postQueued(h, 1, "guid-2"); w.Code != http.StatusAccepted {
+		t.Fatalf("expected failed job to be queued again, got %d", w.Code)
+	}
+	job, _, _ := h.store.Job(1)
+	if job.Status != state.StatusQueued || job.Error != "" {
+		t.Errorf("expected job requeued with error cleared, got %+v", job)
+	}
+}
+
+func TestFailedDeliveryNotRemembered(t *testing.T) {
+	h := NewHandler(Config{
+		WebhookSecret:    []byte(testSecret),
+		MaxPerRepoPerMin: 1,
+	})
+
+	postQueued(h, 1, "guid-1")
+	// Rate limited: the delivery must be accepted when redelivered.
+	if w := postQueued(h, 2, "guid-2"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	if !h.deliveries.claim("guid-2") {
+		t.Error("rejected delivery should not be remembered")
+	}
 }
 
 func TestNewHandlerDefaults(t *testing.T) {
 	h := NewHandler(Config{})
 
-	if h.requiredLabel != "self-hosted" {
-		t.Errorf("expected default label 'self-hosted', got %q", h.requiredLabel)
+	if len(h.pools) != 1 || h.pools[0].Labels[0] != "self-hosted" {
+		t.Errorf("expected default pool on 'self-hosted', got %+v", h.pools)
 	}
 	if h.runnerVersion != "2.331.0" {
 		t.Errorf("expected default version '2.331.0', got %q", h.runnerVersion)
 	}
-	if cap(h.workerPool) != 10 {
-		t.Errorf("expected default pool size 10, got %d", cap(h.workerPool))
+	if h.workers != 10 {
+		t.Errorf("expected default worker count 10, got %d", h.workers)
+	}
+	if h.retry.maxAttempts != 6 {
+		t.Errorf("expected default max attempts 6, got %d", h.retry.maxAttempts)
 	}
 	if h.rateLimiter.limit != 20 {
 		t.Errorf("expected default rate limit 20, got %d", h.rateLimiter.limit)
 	}
 }
+
+func TestJobSpecTTL(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pools.json")
+	if err := os.WriteFile(path, []byte(`{"pools": [{"name": "chef", "labels": ["chef"], "ttl": "90m", "max_ttl": "3h"}, {"name": "default", "labels": ["x"]}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pools, err := pool.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pools[0]
+
+	if spec := jobSpec(p, []string{"self-hosted", "chef"}); spec.TTL != 90*time.Minute {
+		t.Errorf("expected pool TTL, got %v", spec.TTL)
+	}
+	if spec := jobSpec(p, []string{"self-hosted", "chef", "timeout-150m"}); spec.TTL != 150*time.Minute {
+		t.Errorf("expected job label to override pool TTL, got %v", spec.TTL)
+	}
+	if spec := jobSpec(p, []string{"self-hosted", "chef", "timeout-1440m"}); spec.TTL != 3*time.Hour {
+		t.Errorf("expected job label capped at the pool's max_ttl, got %v", spec.TTL)
+	}
+	if spec := jobSpec(pools[1], []string{"x", "timeout-24h"}); spec.TTL != provider.DefaultTTL {
+		t.Errorf("expected job label capped at the default TTL, got %v", spec.TTL)
+	}
+}
+
+func TestProvisioningContinuesDeliveryTrace(t *testing.T) {
+	if _, err := tracing.Setup(context.Background(), "test", ""); err != nil {
+		t.Fatal(err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	h := newTestHandler()
+	postQueued(h, 1, "guid-1")
+
+	job, ok, _ := h.store.Job(1)
+	if !ok || job.Trace["traceparent"] == "" {
+		t.Fatalf("expected queued job to carry trace context, got %+v", job)
+	}
+
+	// Fails validation before any API call, but still records its span.
+	job.Repo = "bad;owner/repo"
+	if err := h.provisionRunner(context.Background(), job); err == nil {
+		t.Fatal("expected invalid repo to fail provisioning")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "webhook.ServeHTTP" || spans[1].Name() != "provisionRunner" {
+		t.Fatalf("unexpected spans %v", spans)
+	}
+	delivery, provision := spans[0].SpanContext(), spans[1]
+	if provision.Parent().SpanID() != delivery.SpanID() || provision.SpanContext().TraceID() != delivery.TraceID() {
+		t.Error("expected provisionRunner span to be a child of the delivery span")
+	}
+}
@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+)
+
+// fakeProvider records calls instead of launching anything.
+type fakeProvider struct {
+	mu        sync.Mutex
+	nextID    int
+	instances map[int]provider.Instance
+	deleted   []int
+}
+
+var _ provider.Provider = (*fakeProvider)(nil)
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{nextID: 1000, instances: make(map[int]provider.Instance)}
+}
+
+func (f *fakeProvider) CreateRunner(_ context.Context, spec provider.Spec, params provider.RunnerParams) (provider.Instance, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	inst := provider.Instance{ID: f.nextID, Name: params.RunnerName, Tags: spec.Tags, Created: time.Now()}
+	f.instances[inst.ID] = inst
+	return inst, nil
+}
+
+func (f *fakeProvider) DeleteRunner(_ context.Context, id int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.instances, id)
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+func (f *fakeProvider) ListRunners(_ context.Context, tag string) ([]provider.Instance, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []provider.Instance
+	for _, inst := range f.instances {
+		if inst.HasTag(tag) {
+			out = append(out, inst)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeProvider) DescribeRunner(_ context.Context, id int) (provider.Instance, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inst, ok := f.instances[id]
+	if !ok {
+		return provider.Instance{}, provider.ErrNotFound
+	}
+	return inst, nil
+}
+
+func (f *fakeProvider) UntagRunner(_ context.Context, id int, tag string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inst := f.instances[id]
+	var tags []string
+	for _, t := range inst.Tags {
+		if t != tag {
+			tags = append(tags, t)
+		}
+	}
+	inst.Tags = tags
+	f.instances[id] = inst
+	return nil
+}
+
+func (f *fakeProvider) PriceHourly(context.Context, string) (float64, error) {
+	return 0.01, nil
+}
+
+func (f *fakeProvider) deletedIDs() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int(nil), f.deleted...)
+}
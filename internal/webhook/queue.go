@@ -0,0 +1,143 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/thomasvincent/github-runners-infra/internal/metrics"
+	"github.com/thomasvincent/github-runners-infra/internal/state"
+)
+
+// pollInterval is how often idle workers look for jobs whose retry is due.
+const pollInterval = time.Second
+
+// errInvalidJob marks provisioning failures that retrying cannot fix.
+var errInvalidJob = errors.New("invalid job")
+
+// retryPolicy controls exponential backoff between provisioning attempts.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+// delay returns the wait before the attempt following the given one.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.baseDelay
+	for i := 1; i < attempt && d < p.maxDelay; i++ {
+		d *= 2
+	}
+	return min(d, p.maxDelay)
+}
+
+// Run drains the provisioning queue, keeps the warm pool stocked, refreshes
+// the hook allowlist and reconciles droplets with GitHub runners if
+// configured, until ctx is cancelled. Jobs left in provisioning by a previous process are requeued
+// first. Run returns once all workers have finished their current job and
+// the droplet deletions of completed jobs are done.
+func (h *Handler) Run(ctx context.Context) {
+	if n, err := h.store.RequeueInterrupted(); err != nil {
+		h.log.Error("Failed to requeue interrupted jobs", "error", err)
+	} else if n > 0 {
+		h.log.Info("Requeued jobs interrupted by restart", "count", n)
+	}
+
+	metrics.Workers.Set(float64(h.workers))
+
+	var wg sync.WaitGroup
+	if h.warm.enabled() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.warm.run(ctx)
+		}()
+	}
+	if h.hookURL != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.refreshHookAllowlist(ctx)
+		}()
+	}
+	if h.reconciler.enabled() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.reconciler.run(ctx)
+		}()
+	}
+	for i := 0; i < h.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.worker(ctx)
+		}()
+	}
+	wg.Wait()
+	h.background.Wait()
+}
+
+func (h *Handler) notifyWorkers() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (h *Handler) worker(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		job, ok, err := h.store.ClaimNext(time.Now())
+		if err != nil {
+			h.log.Error("Failed to claim queued job", "error", err)
+		}
+		if ok {
+			metrics.WorkersBusy.Inc()
+			h.runJob(job)
+			metrics.WorkersBusy.Dec()
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// runJob provisions a claimed job and requeues it with backoff on failure.
+// Provisioning is not tied to the worker context, so shutdown lets the
+// current attempt finish instead of leaving a half-created droplet.
+func (h *Handler) runJob(job state.Job) {
+	start := time.Now()
+	err := h.provision(context.Background(), job)
+	observe := func(result string) {
+		metrics.ProvisionDuration.WithLabelValues(job.Pool, result).Observe(time.Since(start).Seconds())
+	}
+	if err == nil {
+		observe("success")
+		return
+	}
+
+	logger := h.jobLog(job).With("attempt", job.Attempts)
+	if errors.Is(err, errInvalidJob) || job.Attempts >= h.retry.maxAttempts {
+		observe("failed")
+		logger.Error("Job failed", "error", err)
+		h.failJob(job.ID, err.Error())
+		return
+	}
+
+	observe("retry")
+	wait := h.retry.delay(job.Attempts)
+	logger.Warn("Job attempt failed, retrying", "retry_in", wait.String(), "error", err)
+	if _, err := h.store.Retry(job.ID, state.StatusProvisioning, err.Error(), time.Now().Add(wait)); err != nil {
+		logger.Error("Failed to requeue job", "error", err)
+	}
+}
@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thomasvincent/github-runners-infra/internal/state"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := retryPolicy{baseDelay: time.Second, maxDelay: 10 * time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{20, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.delay(tt.attempt); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+// runUntil runs the handler's workers until cond holds or the test times out.
+func runUntil(t *testing.T, h *Handler, cond func() bool) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for queue to drain")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func jobStatus(h *Handler, id int64) state.Status {
+	job, _, _ := h.store.Job(id)
+	return job.Status
+}
+
+func TestQueueRetriesTransientFailures(t *testing.T) {
+	h := NewHandler(Config{MaxAttempts: 3, RetryBaseDelay: time.Millisecond})
+	var calls atomic.Int32
+	h.provision = func(ctx context.Context, job state.Job) error {
+		if calls.Add(1) < 3 {
+			return fmt.Errorf("create droplet: 503")
+		}
+		return h.store.RecordDroplet(job.ID, "eph-repo-1-100", 1001)
+	}
+
+	_, _ = h.store.Enqueue(1, 0, "org/repo", "default", []string{"self-hosted"}, nil)
+	runUntil(t, h, func() bool { return jobStatus(h, 1) == state.StatusDropletCreated })
+
+	if n := calls.Load(); n != 3 {
+		t.Errorf("expected 3 provisioning attempts, got %d", n)
+	}
+}
+
+func TestQueueGivesUpAfterMaxAttempts(t *testing.T) {
+	h := NewHandler(Config{MaxAttempts: 2, RetryBaseDelay: time.Millisecond})
+	var calls atomic.Int32
+	h.provision = func(ctx context.Context, job state.Job) error {
+		calls.Add(1)
+		return errors.New("runner token: unexpected status 502")
+	}
+
+	_, _ = h.store.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	runUntil(t, h, func() bool { return jobStatus(h, 1) == state.StatusFailed })
+
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected 2 provisioning attempts, got %d", n)
+	}
+}
+
+func TestQueueDoesNotRetryInvalidJob(t *testing.T) {
+	h := NewHandler(Config{MaxAttempts: 5, RetryBaseDelay: time.Millisecond})
+	var calls atomic.Int32
+	h.provision = func(ctx context.Context, job state.Job) error {
+		calls.Add(1)
+		return fmt.Errorf("%w: owner/repo", errInvalidJob)
+	}
+
+	_, _ = h.store.Enqueue(1, 0, "bad;owner/repo", "default", nil, nil)
+	runUntil(t, h, func() bool { return jobStatus(h, 1) == state.StatusFailed })
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected a single attempt for an invalid job, got %d", n)
+	}
+}
+
+func TestQueueResumesInterruptedJobs(t *testing.T) {
+	store := state.NewMemory()
+	_, _ = store.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	// A previous process claimed the job and died mid-provision.
+	if _, ok, _ := store.ClaimNext(time.Now()); !ok {
+		t.Fatal("expected to claim job")
+	}
+
+	h := NewHandler(Config{Store: store})
+	h.provision = func(ctx context.Context, job state.Job) error {
+		return h.store.RecordDroplet(job.ID, "eph-repo-1-100", 1001)
+	}
+	runUntil(t, h, func() bool { return jobStatus(h, 1) == state.StatusDropletCreated })
+}
+
+// blockingDeleteProvider holds DeleteRunner calls until release is closed.
+type blockingDeleteProvider struct {
+	*fakeProvider
+	release chan struct{}
+}
+
+func (p *blockingDeleteProvider) DeleteRunner(ctx context.Context, id int) error {
+	<-p.release
+	return p.fakeProvider.DeleteRunner(ctx, id)
+}
+
+func TestRunWaitsForCompletedJobDeletions(t *testing.T) {
+	h := newTestHandler()
+	fake := &blockingDeleteProvider{fakeProvider: newFakeProvider(), release: make(chan struct{})}
+	h.provider = fake
+	postQueued(h, 1, "guid-1")
+	_ = h.store.RecordDroplet(1, "eph-repo-1-100", 1001)
+	postEvent(h, WorkflowJobEvent{
+		Action:      "completed",
+		WorkflowJob: WorkflowJob{ID: 1, Labels: []string{"self-hosted"}, RunnerName: "eph-repo-1-100", Conclusion: "success"},
+		Repo:        RepoInfo{FullName: "org/repo"},
+	}, "guid-2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		h.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Run returned while a droplet deletion was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(fake.release)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after the deletion finished")
+	}
+	if deleted := fake.deletedIDs(); len(deleted) != 1 || deleted[0] != 1001 {
+		t.Errorf("expected droplet 1001 deleted before Run returned, got %v", deleted)
+	}
+}
@@ -0,0 +1,269 @@
+package webhook
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/metrics"
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+)
+
+// runnerTag is carried by every instance a provider launches for a runner.
+const runnerTag = "github-runner"
+
+// Prefixes of the names the listener gives runners of a job and of the warm
+// pool.
+const (
+	jobRunnerPrefix  = "eph-"
+	warmRunnerPrefix = "warm-"
+)
+
+// EphemeralRunnerName reports whether a runner name was generated by the
+// listener for a job or the warm pool, rather than registered by hand.
+func EphemeralRunnerName(name string) bool {
+	return strings.HasPrefix(name, jobRunnerPrefix) || strings.HasPrefix(name, warmRunnerPrefix)
+}
+
+// scopedRunner is a GitHub runner and where it is registered. Repo is empty
+// for org runners.
+type scopedRunner struct {
+	gh.Runner
+	Installation int64
+	Owner        string
+	Repo         string
+}
+
+// reconciler cross-checks runner instances against the runners GitHub has
+// registered, so neither outlives the other. Only instances and runners
+// recorded in the state store are touched; other listeners may share the
+// account and the repos.
+//   - instances whose runner is gone (finished, or deregistered) or never
+//     came online within the grace period are deleted
+//   - runners whose instance no longer exists are deregistered once they
+//     have been orphaned for the grace period
+type reconciler struct {
+	h        *Handler
+	interval time.Duration
+	grace    time.Duration
+
+	// listRunners and removeRunner talk to GitHub; replaced in tests.
+	listRunners  func(ctx context.Context) ([]scopedRunner, error)
+	removeRunner func(ctx context.Context, r scopedRunner) error
+
+	mu       sync.Mutex
+	orphaned map[string]time.Time // runner name -> first pass it had no instance
+}
+
+func newReconciler(h *Handler, interval, grace time.Duration) *reconciler {
+	r := &reconciler{
+		h:        h,
+		interval: interval,
+		grace:    grace,
+		orphaned: make(map[string]time.Time),
+	}
+	r.listRunners = r.listGitHubRunners
+	r.removeRunner = r.removeGitHubRunner
+	return r
+}
+
+// enabled reports whether reconciliation was configured.
+func (r *reconciler) enabled() bool {
+	return r.interval > 0
+}
+
+// run reconciles every interval until ctx is cancelled.
+func (r *reconciler) run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reconcile(ctx)
+		}
+	}
+}
+
+// reconcile runs one pass. Runners are listed before instances, so an
+// instance created in between is simply not seen as orphaned.
+func (r *reconciler) reconcile(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	// A partial runner list would make live instances look abandoned, so
+	// skip the pass on any listing error.
+	runners, err := r.listRunners(ctx)
+	if err != nil {
+		r.h.log.Error("Failed to list GitHub runners for reconciliation", "error", err)
+		return
+	}
+	instances, err := r.h.provider.ListRunners(ctx, runnerTag)
+	if err != nil {
+		r.h.log.Error("Failed to list runner instances for reconciliation", "error", err)
+		return
+	}
+
+	byName := make(map[string]scopedRunner, len(runners))
+	for _, rn := range runners {
+		byName[rn.Name] = rn
+	}
+
+	now := time.Now()
+	exists := make(map[string]bool, len(instances))
+	for _, inst := range instances {
+		exists[inst.Name] = true
+		if now.Sub(inst.Created) < r.grace {
+			continue // still booting
+		}
+		if !r.recorded(inst.ID) {
+			// Launched by another listener, or for an installation this one
+			// does not serve; its runner would not be in the list.
+			continue
+		}
+		if r.h.excluded(inst) {
+			continue // under debugging
+		}
+		rn, registered := byName[inst.Name]
+		switch {
+		case !registered:
+			r.deleteInstance(ctx, inst, "runner not registered")
+		case rn.Status == "offline" && !rn.Busy:
+			if r.deleteInstance(ctx, inst, "runner never came online") {
+				r.deregister(ctx, rn, "instance deleted")
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name := range r.orphaned {
+		if _, ok := byName[name]; !ok || exists[name] {
+			delete(r.orphaned, name)
+		}
+	}
+	for _, rn := range runners {
+		if exists[rn.Name] || !r.recordedRunner(rn.Name) {
+			// Runners of another listener have their instances elsewhere.
+			continue
+		}
+		since, seen := r.orphaned[rn.Name]
+		if !seen {
+			r.orphaned[rn.Name] = now
+			continue
+		}
+		if now.Sub(since) >= r.grace && r.deregister(ctx, rn, "instance no longer exists") {
+			delete(r.orphaned, rn.Name)
+		}
+	}
+}
+
+// recorded reports whether this listener recorded launching the instance.
+func (r *reconciler) recorded(id int) bool {
+	_, ok, err := r.h.store.Droplet(id)
+	if err != nil {
+		r.h.log.Error("Failed to look up droplet for reconciliation", "droplet_id", id, "error", err)
+	}
+	return ok
+}
+
+// recordedRunner reports whether this listener recorded launching an
+// instance for the runner, even if the instance has since been deleted.
+func (r *reconciler) recordedRunner(name string) bool {
+	ok, err := r.h.store.HasRunner(name)
+	if err != nil {
+		r.h.log.Error("Failed to look up runner for reconciliation", "runner_name", name, "error", err)
+	}
+	return ok
+}
+
+func (r *reconciler) deleteInstance(ctx context.Context, inst provider.Instance, reason string) bool {
+	logger := r.h.log.With("runner_name", inst.Name, "droplet_id", inst.ID)
+	logger.Info("Reconciler deleting runner droplet", "reason", reason, "created", inst.Created)
+	if err := r.h.provider.DeleteRunner(ctx, inst.ID); err != nil {
+		metrics.ProviderErrors.WithLabelValues("delete").Inc()
+		logger.Error("Failed to delete droplet", "error", err)
+		return false
+	}
+	if err := r.h.store.MarkDropletDeleted(inst.ID); err != nil {
+		logger.Error("Failed to record droplet deleted", "error", err)
+	}
+	metrics.Reconciled.WithLabelValues("droplet_deleted").Inc()
+	return true
+}
+
+func (r *reconciler) deregister(ctx context.Context, rn scopedRunner, reason string) bool {
+	logger := r.h.log.With("runner_name", rn.Name, "runner_id", rn.ID, "owner", rn.Owner, "repo", rn.Repo)
+	logger.Info("Reconciler deregistering runner", "reason", reason, "status", rn.Status)
+	if err := r.removeRunner(ctx, rn); err != nil {
+		logger.Error("Failed to deregister runner", "error", err)
+		return false
+	}
+	metrics.Reconciled.WithLabelValues("runner_removed").Inc()
+	return true
+}
+
+// listGitHubRunners returns the runners created by this system in every repo
+// of each served installation and, for org installations, in the org.
+func (r *reconciler) listGitHubRunners(ctx context.Context) ([]scopedRunner, error) {
+	installations, err := r.h.appInstallations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []scopedRunner
+	for _, inst := range installations {
+		out, err = r.appendInstallationRunners(gh.WithInstallation(ctx, inst.ID), out, inst)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (r *reconciler) appendInstallationRunners(ctx context.Context, out []scopedRunner, inst gh.Installation) ([]scopedRunner, error) {
+	app := r.h.githubApp
+	repos, err := app.ListInstallationRepos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, repo := range repos {
+		runners, err := app.ListRepoRunners(ctx, repo[0], repo[1])
+		if err != nil {
+			return nil, err
+		}
+		out = appendOwnRunners(out, runners, inst.ID, repo[0], repo[1])
+	}
+
+	if inst.Account.Type == "Organization" {
+		runners, err := app.ListOrgRunners(ctx, inst.Account.Login)
+		if err != nil {
+			return nil, err
+		}
+		out = appendOwnRunners(out, runners, inst.ID, inst.Account.Login, "")
+	}
+	return out, nil
+}
+
+func (r *reconciler) removeGitHubRunner(ctx context.Context, rn scopedRunner) error {
+	ctx = gh.WithInstallation(ctx, rn.Installation)
+	if rn.Repo == "" {
+		return r.h.githubApp.RemoveOrgRunner(ctx, rn.Owner, rn.ID)
+	}
+	return r.h.githubApp.RemoveRepoRunner(ctx, rn.Owner, rn.Repo, rn.ID)
+}
+
+// appendOwnRunners appends the runners named by the listener for a job or
+// the warm pool; runners registered by hand are left alone.
+func appendOwnRunners(out []scopedRunner, runners []gh.Runner, installation int64, owner, repo string) []scopedRunner {
+	for _, rn := range runners {
+		if EphemeralRunnerName(rn.Name) {
+			out = append(out, scopedRunner{Runner: rn, Installation: installation, Owner: owner, Repo: repo})
+		}
+	}
+	return out
+}
@@ -0,0 +1,146 @@
+package webhook
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+)
+
+func newReconcileTestHandler(runners []scopedRunner) (*Handler, *fakeProvider, *[]string) {
+	fake := newFakeProvider()
+	h := NewHandler(Config{
+		WebhookSecret:     []byte(testSecret),
+		Provider:          fake,
+		ReconcileInterval: time.Minute,
+		ReconcileGrace:    10 * time.Minute,
+	})
+	var removed []string
+	h.reconciler.listRunners = func(context.Context) ([]scopedRunner, error) { return runners, nil }
+	h.reconciler.removeRunner = func(_ context.Context, r scopedRunner) error {
+		removed = append(removed, r.Name)
+		return nil
+	}
+	return h, fake, &removed
+}
+
+func TestReconcileDeletesDropletsWithoutLiveRunner(t *testing.T) {
+	h, fake, removed := newReconcileTestHandler([]scopedRunner{
+		{Runner: gh.Runner{ID: 1, Name: "eph-repo-1", Status: "online", Busy: true}, Owner: "org", Repo: "repo"},
+		{Runner: gh.Runner{ID: 2, Name: "eph-repo-2", Status: "offline"}, Owner: "org", Repo: "repo"},
+		{Runner: gh.Runner{ID: 4, Name: "eph-repo-4", Status: "offline"}, Owner: "org", Repo: "repo"},
+	})
+	tags := []string{runnerTag}
+	old := time.Now().Add(-time.Hour)
+	fake.instances[1] = provider.Instance{ID: 1, Name: "eph-repo-1", Tags: tags, Created: old}
+	fake.instances[2] = provider.Instance{ID: 2, Name: "eph-repo-2", Tags: tags, Created: old}
+	fake.instances[3] = provider.Instance{ID: 3, Name: "eph-repo-3", Tags: tags, Created: old}
+	fake.instances[4] = provider.Instance{ID: 4, Name: "eph-repo-4", Tags: tags, Created: time.Now()}
+	recordDroplets(h, fake)
+
+	h.reconciler.reconcile(context.Background())
+
+	deleted := fake.deletedIDs()
+	slices.Sort(deleted)
+	if !slices.Equal(deleted, []int{2, 3}) {
+		t.Errorf("expected offline and unregistered runners' droplets deleted, got %v", deleted)
+	}
+	if !slices.Equal(*removed, []string{"eph-repo-2"}) {
+		t.Errorf("expected runner of deleted droplet deregistered, got %v", *removed)
+	}
+}
+
+// recordDroplets records every instance of fake as launched by h.
+func recordDroplets(h *Handler, fake *fakeProvider) {
+	for id, inst := range fake.instances {
+		_, _ = h.store.Enqueue(int64(id), 0, "org/repo", "default", nil, nil)
+		_ = h.store.RecordDroplet(int64(id), inst.Name, id)
+	}
+}
+
+func TestReconcileLeavesUnrecordedDroplets(t *testing.T) {
+	h, fake, _ := newReconcileTestHandler(nil)
+	old := time.Now().Add(-time.Hour)
+	fake.instances[1] = provider.Instance{ID: 1, Name: "eph-other-1", Tags: []string{runnerTag}, Created: old}
+
+	h.reconciler.reconcile(context.Background())
+
+	if deleted := fake.deletedIDs(); len(deleted) != 0 {
+		t.Errorf("expected droplet of another listener left alone, got %v deleted", deleted)
+	}
+}
+
+func TestReconcileKeepsExcludedDroplets(t *testing.T) {
+	h, fake, removed := newReconcileTestHandler([]scopedRunner{
+		{Runner: gh.Runner{ID: 2, Name: "eph-repo-2", Status: "offline"}, Owner: "org", Repo: "repo"},
+	})
+	old := time.Now().Add(-time.Hour)
+	fake.instances[1] = provider.Instance{ID: 1, Name: "eph-repo-1", Tags: []string{runnerTag, "debug"}, Created: old}
+	fake.instances[2] = provider.Instance{ID: 2, Name: "eph-repo-2", Tags: []string{runnerTag, "debug"}, Created: old}
+	recordDroplets(h, fake)
+
+	h.reconciler.reconcile(context.Background())
+
+	if deleted := fake.deletedIDs(); len(deleted) != 0 {
+		t.Errorf("expected droplets tagged debug kept, got %v deleted", deleted)
+	}
+	if len(*removed) != 0 {
+		t.Errorf("expected runners of droplets tagged debug kept, got %v removed", *removed)
+	}
+}
+
+func TestReconcileLeavesUnrecordedRunners(t *testing.T) {
+	h, _, removed := newReconcileTestHandler([]scopedRunner{
+		{Runner: gh.Runner{ID: 1, Name: "eph-other-1", Status: "offline"}, Owner: "org", Repo: "repo"},
+	})
+
+	// Launched by another listener, whose instances this one cannot see.
+	h.reconciler.reconcile(context.Background())
+	h.reconciler.mu.Lock()
+	h.reconciler.orphaned["eph-other-1"] = time.Now().Add(-time.Hour)
+	h.reconciler.mu.Unlock()
+	h.reconciler.reconcile(context.Background())
+
+	if len(*removed) != 0 {
+		t.Errorf("expected runner of another listener left alone, got %v removed", *removed)
+	}
+}
+
+func TestReconcileDeregistersOrphanedRunnersAfterGrace(t *testing.T) {
+	h, _, removed := newReconcileTestHandler([]scopedRunner{
+		{Runner: gh.Runner{ID: 1, Name: "eph-repo-1", Status: "offline"}, Owner: "org", Repo: "repo"},
+	})
+	// The droplet was launched by this listener and has since vanished.
+	_, _ = h.store.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	_ = h.store.RecordDroplet(1, "eph-repo-1", 1)
+	_ = h.store.MarkDropletDeleted(1)
+
+	h.reconciler.reconcile(context.Background())
+	if len(*removed) != 0 {
+		t.Fatalf("runner may still be waiting for its droplet, got %v removed", *removed)
+	}
+
+	h.reconciler.mu.Lock()
+	h.reconciler.orphaned["eph-repo-1"] = time.Now().Add(-11 * time.Minute)
+	h.reconciler.mu.Unlock()
+
+	h.reconciler.reconcile(context.Background())
+	if !slices.Equal(*removed, []string{"eph-repo-1"}) {
+		t.Errorf("expected orphaned runner deregistered, got %v", *removed)
+	}
+	if len(h.reconciler.orphaned) != 0 {
+		t.Errorf("expected orphan tracking cleared, got %v", h.reconciler.orphaned)
+	}
+}
+
+func TestAppendOwnRunnersSkipsManualRunners(t *testing.T) {
+	out := appendOwnRunners(nil, []gh.Runner{
+		{Name: "eph-repo-1"}, {Name: "warm-chef-1"}, {Name: "build-box"},
+	}, 7, "org", "")
+	if len(out) != 2 || out[0].Name != "eph-repo-1" || out[1].Name != "warm-chef-1" || out[0].Installation != 7 {
+		t.Errorf("expected only listener-created runners, got %v", out)
+	}
+}
@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+)
+
+// defaultSecretID names the secret given as Config.WebhookSecret.
+const defaultSecretID = "default"
+
+// LoadWebhookSecrets reads a JSON list of webhook secrets, e.g.
+//
+//	[{"id": "2026-10", "secret": "..."},
+//	 {"id": "2026-04", "secret": "...", "expires": "2026-10-20T00:00:00Z"}]
+func LoadWebhookSecrets(path string) ([]gh.WebhookSecret, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read webhook secrets: %w", err)
+	}
+	secrets, err := parseWebhookSecrets(data)
+	if err != nil {
+		return nil, fmt.Errorf("webhook secrets %s: %w", path, err)
+	}
+	return secrets, nil
+}
+
+func parseWebhookSecrets(data []byte) ([]gh.WebhookSecret, error) {
+	var secrets []gh.WebhookSecret
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	if err := validateSecrets(secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func validateSecrets(secrets []gh.WebhookSecret) error {
+	if len(secrets) == 0 {
+		return errors.New("no webhook secrets")
+	}
+	seen := make(map[string]bool, len(secrets))
+	for i, s := range secrets {
+		if s.ID == "" || s.Secret == "" {
+			return fmt.Errorf("secret %d: id and secret are required", i)
+		}
+		if seen[s.ID] {
+			return fmt.Errorf("duplicate secret id %q", s.ID)
+		}
+		seen[s.ID] = true
+	}
+	return nil
+}
+
+// SetWebhookSecrets replaces the secrets deliveries are verified with.
+func (h *Handler) SetWebhookSecrets(secrets []gh.WebhookSecret) error {
+	if err := validateSecrets(secrets); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, s := range secrets {
+		if s.Expired(now) {
+			h.log.Warn("Webhook secret already expired", "webhook_secret", s.ID, "expired", s.Expires)
+		}
+	}
+
+	h.secretsMu.Lock()
+	defer h.secretsMu.Unlock()
+	h.webhookSecrets = append([]gh.WebhookSecret(nil), secrets...)
+	return nil
+}
+
+func (h *Handler) secrets() []gh.WebhookSecret {
+	h.secretsMu.RLock()
+	defer h.secretsMu.RUnlock()
+	return h.webhookSecrets
+}
+
+// WatchWebhookSecrets polls the secrets file at path every interval and
+// loads it whenever its secrets differ from the current ones, until ctx is
+// cancelled. A file that fails to load is logged and the current secrets kept.
+func (h *Handler) WatchWebhookSecrets(ctx context.Context, path string, interval time.Duration) {
+	var last []byte
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			h.log.Error("Failed to read webhook secrets", "path", path, "error", err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		secrets, err := parseWebhookSecrets(data)
+		if err == nil && slices.Equal(secrets, h.secrets()) {
+			continue
+		}
+		if err == nil {
+			err = h.SetWebhookSecrets(secrets)
+		}
+		if err != nil {
+			h.log.Error("Failed to load changed webhook secrets, keeping current secrets", "path", path, "error", err)
+			continue
+		}
+		h.log.Info("Reloaded webhook secrets", "path", path, "count", len(secrets))
+	}
+}
@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/metrics"
+)
+
+func TestPreviousWebhookSecretAccepted(t *testing.T) {
+	h := NewHandler(Config{WebhookSecrets: []gh.WebhookSecret{
+		{ID: "new", Secret: "new-secret"},
+		{ID: "old", Secret: testSecret, Expires: time.Now().Add(time.Hour)},
+	}})
+	matches := metrics.SignatureMatches.WithLabelValues("old")
+	before := testutil.ToFloat64(matches)
+
+	if w := postQueued(h, 1, "guid-old-secret"); w.Code != http.StatusAccepted {
+		t.Fatalf("expected delivery signed with previous secret accepted, got %d", w.Code)
+	}
+	if got := testutil.ToFloat64(matches); got != before+1 {
+		t.Errorf("expected match counted for secret old, got %v (before %v)", got, before)
+	}
+}
+
+func TestExpiredWebhookSecretRejected(t *testing.T) {
+	h := NewHandler(Config{WebhookSecrets: []gh.WebhookSecret{
+		{ID: "new", Secret: "new-secret"},
+		{ID: "old", Secret: testSecret, Expires: time.Now().Add(-time.Minute)},
+	}})
+	if w := postQueued(h, 1, "guid-expired"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected delivery signed with expired secret rejected, got %d", w.Code)
+	}
+}
+
+func TestLoadWebhookSecretsValidates(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, json string
+		ok         bool
+	}{
+		{"valid", `[{"id":"a","secret":"x"},{"id":"b","secret":"y","expires":"2026-01-01T00:00:00Z"}]`, true},
+		{"empty", `[]`, false},
+		{"missing secret", `[{"id":"a"}]`, false},
+		{"duplicate id", `[{"id":"a","secret":"x"},{"id":"a","secret":"y"}]`, false},
+		{"not json", `secret`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			if err := os.WriteFile(path, []byte(tt.json), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadWebhookSecrets(path)
+			if (err == nil) != tt.ok {
+				t.Errorf("LoadWebhookSecrets() error = %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestWatchWebhookSecretsReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	if err := os.WriteFile(path, []byte(`[{"id":"old","secret":"other"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(Config{WebhookSecrets: []gh.WebhookSecret{{ID: "old", Secret: "other"}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.WatchWebhookSecrets(ctx, path, 10*time.Millisecond)
+
+	if err := os.WriteFile(path, []byte(`[{"id":"new","secret":"`+testSecret+`"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if s := h.secrets(); len(s) == 1 && s[0].ID == "new" {
+			if w := postQueued(h, 1, "guid-reloaded"); w.Code != http.StatusAccepted {
+				t.Errorf("expected reloaded secret accepted, got %d", w.Code)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected changed secrets file to be reloaded")
+}
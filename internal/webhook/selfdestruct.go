@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thomasvincent/github-runners-infra/internal/metrics"
+)
+
+// Runners delete their own droplet by calling back into the listener instead
+// of holding a DigitalOcean token, which untrusted job code could read from
+// the metadata service. Each droplet gets a token bound to its runner name;
+// once the droplet is recorded deleted the token is spent.
+
+// selfDestructURL returns the callback URL rendered into a runner's cloud-init.
+func (h *Handler) selfDestructURL(runnerName string) string {
+	return h.callbackURL + "/self-destruct?runner=" + url.QueryEscape(runnerName)
+}
+
+// selfDestructToken returns the HMAC token authorizing runnerName to delete
+// its droplet.
+func (h *Handler) selfDestructToken(runnerName string) string {
+	mac := hmac.New(sha256.New, h.callbackKey)
+	mac.Write([]byte("self-destruct:" + runnerName))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServeSelfDestruct handles POST /self-destruct?runner=<name> from a runner
+// droplet that has finished its job, deleting the droplet on its behalf.
+func (h *Handler) ServeSelfDestruct(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientIP := h.clientIP(r)
+	runnerName := r.URL.Query().Get("runner")
+	logger := h.log.With("runner_name", runnerName)
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !safeNameRegex.MatchString(runnerName) ||
+		!hmac.Equal([]byte(token), []byte(h.selfDestructToken(runnerName))) {
+		logger.Warn("Invalid self-destruct token", "security", true, "client_ip", clientIP)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	droplet, ok, err := h.store.RunnerDroplet(runnerName)
+	if err != nil {
+		logger.Error("Failed to look up runner droplet", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		// Already deleted (token spent) or never provisioned by us.
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	logger = logger.With("droplet_id", droplet.ID)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+	defer cancel()
+	excluded, err := h.excludedDroplet(ctx, droplet.ID)
+	if err != nil {
+		metrics.ProviderErrors.WithLabelValues("describe").Inc()
+		logger.Error("Failed to look up droplet to self-destruct", "error", err)
+		http.Error(w, "lookup failed", http.StatusBadGateway)
+		return
+	}
+	if excluded {
+		logger.Info("Keeping excluded droplet on self-destruct", "tag", h.excludeTag)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := h.provider.DeleteRunner(ctx, droplet.ID); err != nil {
+		metrics.ProviderErrors.WithLabelValues("delete").Inc()
+		logger.Error("Failed to self-destruct droplet", "error", err)
+		http.Error(w, "delete failed", http.StatusBadGateway)
+		return
+	}
+	if err := h.store.MarkDropletDeleted(droplet.ID); err != nil {
+		logger.Error("Failed to record droplet deleted", "error", err)
+	}
+	logger.Info("Deleted droplet on self-destruct")
+	w.WriteHeader(http.StatusNoContent)
+}
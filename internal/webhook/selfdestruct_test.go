@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func postSelfDestruct(h *Handler, runnerName, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/self-destruct?runner="+runnerName, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeSelfDestruct(w, req)
+	return w
+}
+
+func TestSelfDestructURL(t *testing.T) {
+	h := NewHandler(Config{
+		WebhookSecret: []byte(testSecret),
+		CallbackURL:   "https://runners.example.com/",
+	})
+	got := h.selfDestructURL("eph-repo-1-100")
+	if got != "https://runners.example.com/self-destruct?runner=eph-repo-1-100" {
+		t.Errorf("selfDestructURL() = %q", got)
+	}
+}
+
+func TestSelfDestructTokenBoundToRunner(t *testing.T) {
+	h := newTestHandler()
+	if h.selfDestructToken("eph-a") == h.selfDestructToken("eph-b") {
+		t.Error("tokens must differ per runner")
+	}
+
+	other := NewHandler(Config{WebhookSecret: []byte(testSecret), CallbackSecret: []byte("other")})
+	if h.selfDestructToken("eph-a") == other.selfDestructToken("eph-a") {
+		t.Error("token must depend on the callback secret")
+	}
+}
+
+func TestSelfDestructTokenNotSignedWithWebhookSecret(t *testing.T) {
+	h := newTestHandler()
+	signed := NewHandler(Config{WebhookSecret: []byte(testSecret), CallbackSecret: []byte(testSecret)})
+	if h.selfDestructToken("eph-a") == signed.selfDestructToken("eph-a") {
+		t.Error("without a callback secret, tokens must not be signed with the webhook secret")
+	}
+}
+
+func TestSelfDestructDeletesDropletOnce(t *testing.T) {
+	h := newTestHandler()
+	fake := newFakeProvider()
+	h.provider = fake
+	_, _ = h.store.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	_ = h.store.RecordDroplet(1, "eph-repo-1-100", 1001)
+
+	token := h.selfDestructToken("eph-repo-1-100")
+	if w := postSelfDestruct(h, "eph-repo-1-100", token); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if deleted := fake.deletedIDs(); len(deleted) != 1 || deleted[0] != 1001 {
+		t.Fatalf("expected droplet 1001 deleted, got %v", deleted)
+	}
+
+	// The token is spent once the droplet is recorded deleted.
+	if w := postSelfDestruct(h, "eph-repo-1-100", token); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 on replay, got %d", w.Code)
+	}
+	if deleted := fake.deletedIDs(); len(deleted) != 1 {
+		t.Errorf("replay must not delete again, got %v", deleted)
+	}
+}
+
+func TestSelfDestructRejectsBadToken(t *testing.T) {
+	h := newTestHandler()
+	fake := newFakeProvider()
+	h.provider = fake
+	_, _ = h.store.Enqueue(1, 0, "org/repo", "default", nil, nil)
+	_ = h.store.RecordDroplet(1, "eph-repo-1-100", 1001)
+	_ = h.store.RecordDroplet(1, "eph-repo-2-100", 1002)
+
+	tests := []struct {
+		name   string
+		runner string
+		token  string
+	}{
+		{"missing token", "eph-repo-1-100", ""},
+		{"other runner's token", "eph-repo-1-100", h.selfDestructToken("eph-repo-2-100")},
+		{"invalid runner name", "eph;rm", h.selfDestructToken("eph;rm")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := postSelfDestruct(h, tt.runner, tt.token); w.Code != http.StatusUnauthorized {
+				t.Errorf("expected 401, got %d", w.Code)
+			}
+		})
+	}
+	if deleted := fake.deletedIDs(); len(deleted) != 0 {
+		t.Errorf("no droplet may be deleted, got %v", deleted)
+	}
+}
+
+func TestSelfDestructMethodNotAllowed(t *testing.T) {
+	h := newTestHandler()
+	req := httptest.NewRequest(http.MethodGet, "/self-destruct?runner=eph-a", nil)
+	w := httptest.NewRecorder()
+	h.ServeSelfDestruct(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", w.Code)
+	}
+}
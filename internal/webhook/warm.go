@@ -0,0 +1,365 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gh "github.com/thomasvincent/github-runners-infra/internal/github"
+	"github.com/thomasvincent/github-runners-infra/internal/metrics"
+	"github.com/thomasvincent/github-runners-infra/internal/pool"
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+	"github.com/thomasvincent/github-runners-infra/internal/state"
+)
+
+const (
+	// warmInterval is how often the warm pool is reconciled without a trigger.
+	warmInterval = time.Minute
+	// warmMaxIdleAge recycles idle runners well before the cleanup timer or
+	// the cloud-init safety net would kill them mid-job after being claimed.
+	warmMaxIdleAge = 20 * time.Minute
+	// warmReservationTTL bounds how long a queued job holds an idle runner.
+	warmReservationTTL = 10 * time.Minute
+)
+
+// warmPool keeps pools with a warm config stocked with idle, pre-registered
+// runners. Idle runners carry provider.WarmTag and their pool tag; the
+// tag is removed when a job claims the runner, and a replacement is booted.
+type warmPool struct {
+	h             *Handler
+	maxHourlyCost float64 // cap on the hourly price of all idle runners, 0 = no cap
+	kick          chan struct{}
+
+	mu       sync.Mutex
+	idle     map[string]int           // idle runners per pool at last reconcile
+	reserved map[string][]reservation // queued jobs expected to land on an idle runner
+}
+
+type reservation struct {
+	jobID int64
+	at    time.Time
+}
+
+func newWarmPool(h *Handler, maxHourlyCost float64) *warmPool {
+	return &warmPool{
+		h:             h,
+		maxHourlyCost: maxHourlyCost,
+		kick:          make(chan struct{}, 1),
+		idle:          make(map[string]int),
+		reserved:      make(map[string][]reservation),
+	}
+}
+
+// enabled reports whether any pool wants idle runners.
+func (w *warmPool) enabled() bool {
+	for _, p := range w.h.pools {
+		if p.Warm != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// reserve holds an idle runner of pool p for a queued job from repo. It
+// returns false if none is available, in which case the job gets its own
+// droplet, or if provisioning for repo is paused, in which case the job
+// waits in the queue.
+func (w *warmPool) reserve(p *pool.Pool, repo string, jobID int64) bool {
+	if p.Warm == nil || !servesRepo(p, repo) {
+		return false
+	}
+	if paused, err := w.h.store.IsPaused(repo); err != nil || paused {
+		return false
+	}
+
+	w.mu.Lock()
+	expired := w.pruneReservationsLocked(p.Name, time.Now())
+	ok := w.idle[p.Name]-len(w.reserved[p.Name]) > 0
+	if ok {
+		w.reserved[p.Name] = append(w.reserved[p.Name], reservation{jobID: jobID, at: time.Now()})
+	}
+	w.mu.Unlock()
+
+	w.requeue(p.Name, expired)
+	if ok {
+		w.trigger()
+	}
+	return ok
+}
+
+// servesRepo reports whether idle runners of p can pick up jobs from repo:
+// repo runners only serve their own repo, org runners any repo of the org.
+func servesRepo(p *pool.Pool, repo string) bool {
+	if !p.OrgScoped() {
+		return strings.EqualFold(p.Warm.Repo, repo)
+	}
+	warmOwner, _, _ := strings.Cut(p.Warm.Repo, "/")
+	owner, _, _ := strings.Cut(repo, "/")
+	return strings.EqualFold(warmOwner, owner)
+}
+
+// claimed removes the warm tag from a runner that picked up jobID and
+// schedules a replacement. GitHub may hand the runner any matching job, so
+// if jobID holds no reservation the oldest one is consumed instead.
+func (w *warmPool) claimed(poolName string, dropletID int, jobID int64) {
+	w.mu.Lock()
+	if w.idle[poolName] > 0 {
+		w.idle[poolName]--
+	}
+	if !w.dropReservationLocked(poolName, jobID) && len(w.reserved[poolName]) > 0 {
+		w.reserved[poolName] = w.reserved[poolName][1:]
+	}
+	w.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := w.h.provider.UntagRunner(ctx, dropletID, provider.WarmTag); err != nil {
+			metrics.ProviderErrors.WithLabelValues("untag").Inc()
+			w.h.log.Error("Failed to untag claimed warm droplet", "pool", poolName, "droplet_id", dropletID, "job_id", jobID, "error", err)
+		}
+		w.trigger()
+	}()
+}
+
+// release drops the reservation of a job that landed on a runner outside
+// the warm pool, or finished without running, leaving the idle runner for
+// another job.
+func (w *warmPool) release(jobID int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for name := range w.reserved {
+		if w.dropReservationLocked(name, jobID) {
+			return
+		}
+	}
+}
+
+func (w *warmPool) dropReservationLocked(poolName string, jobID int64) bool {
+	r := w.reserved[poolName]
+	for i := range r {
+		if r[i].jobID == jobID {
+			w.reserved[poolName] = append(r[:i:i], r[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (w *warmPool) trigger() {
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+// pruneReservationsLocked drops expired reservations and returns their jobs,
+// to be requeued with requeue once w.mu is released.
+func (w *warmPool) pruneReservationsLocked(poolName string, now time.Time) []int64 {
+	var expired []int64
+	r := w.reserved[poolName]
+	for len(r) > 0 && now.Sub(r[0].at) > warmReservationTTL {
+		expired = append(expired, r[0].jobID)
+		r = r[1:]
+	}
+	w.reserved[poolName] = r
+	return expired
+}
+
+// requeue returns jobs whose reservation expired to the queue so they get a
+// dedicated droplet instead. Jobs that finished meanwhile stay finished.
+func (w *warmPool) requeue(poolName string, jobIDs []int64) {
+	for _, jobID := range jobIDs {
+		logger := w.h.log.With("job_id", jobID, "pool", poolName)
+		requeued, err := w.h.store.Retry(jobID, state.StatusWarmReserved, "warm runner not available", time.Now())
+		if err != nil {
+			logger.Error("Failed to requeue job", "error", err)
+			continue
+		}
+		if requeued {
+			logger.Warn("No warm runner picked up job, provisioning one", "waited", warmReservationTTL.String())
+			w.h.notifyWorkers()
+		}
+	}
+}
+
+// run reconciles the warm pool until ctx is cancelled.
+func (w *warmPool) run(ctx context.Context) {
+	ticker := time.NewTicker(warmInterval)
+	defer ticker.Stop()
+
+	for {
+		w.reconcile(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.kick:
+		}
+	}
+}
+
+// reconcile recycles stale idle runners, then boots or deletes runners so
+// each pool has its scheduled number available, within the cost cap.
+func (w *warmPool) reconcile(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	instances, err := w.h.provider.ListRunners(ctx, provider.WarmTag)
+	if err != nil {
+		w.h.log.Error("Failed to list warm runners", "error", err)
+		return
+	}
+
+	byPool := make(map[string][]provider.Instance)
+	var hourlyCost float64
+	for _, inst := range instances {
+		if w.isClaimed(inst.ID) {
+			continue // untag in flight
+		}
+		p := w.poolOf(inst)
+		if p == nil {
+			// Its pool was removed or lost its warm block; nothing else
+			// would ever recycle it.
+			if w.deleteIdle(ctx, inst) {
+				w.h.log.Info("Removed warm runner of unknown pool", "runner_name", inst.Name, "droplet_id", inst.ID)
+				continue
+			}
+		} else {
+			byPool[p.Name] = append(byPool[p.Name], inst)
+		}
+		hourlyCost += inst.PriceHourly
+	}
+
+	now := time.Now()
+	for _, p := range w.h.pools {
+		if p.Warm == nil {
+			continue
+		}
+		idle := w.reconcilePool(ctx, p, byPool[p.Name], &hourlyCost, now)
+
+		w.mu.Lock()
+		w.idle[p.Name] = idle
+		w.mu.Unlock()
+	}
+}
+
+// poolOf returns the warm pool an idle runner belongs to, or nil if no pool
+// with a warm config claims it.
+func (w *warmPool) poolOf(inst provider.Instance) *pool.Pool {
+	for _, p := range w.h.pools {
+		if p.Warm != nil && inst.HasTag(p.Tag()) {
+			return p
+		}
+	}
+	return nil
+}
+
+// reconcilePool brings one pool to its target and returns its idle count.
+// hourlyCost tracks the price of all idle runners across pools.
+func (w *warmPool) reconcilePool(ctx context.Context, p *pool.Pool, instances []provider.Instance, hourlyCost *float64, now time.Time) int {
+	sort.Slice(instances, func(i, k int) bool { return instances[i].Created.Before(instances[k].Created) })
+
+	w.mu.Lock()
+	expired := w.pruneReservationsLocked(p.Name, now)
+	reserved := len(w.reserved[p.Name])
+	w.mu.Unlock()
+	w.requeue(p.Name, expired)
+
+	target := p.Warm.Target(now)
+	excess := len(instances) - reserved - target
+
+	logger := w.h.log.With("pool", p.Name)
+	idle := 0
+	for _, inst := range instances {
+		stale := now.Sub(inst.Created) > warmMaxIdleAge
+		if stale || excess > 0 {
+			if w.deleteIdle(ctx, inst) {
+				logger.Info("Removed idle warm runner", "runner_name", inst.Name, "droplet_id", inst.ID, "stale", stale)
+				*hourlyCost -= inst.PriceHourly
+				excess--
+				continue
+			}
+		}
+		idle++
+	}
+
+	// Scale up within the cost cap, unless provisioning is paused.
+	if paused, err := w.h.store.IsPaused(p.Warm.Repo); err != nil || paused {
+		return idle
+	}
+	for available := idle - reserved; available < target; available++ {
+		price, err := w.h.provider.PriceHourly(ctx, p.Size)
+		if err != nil {
+			logger.Error("Failed to get runner price", "error", err)
+			break
+		}
+		if w.maxHourlyCost > 0 && *hourlyCost+price > w.maxHourlyCost {
+			logger.Warn("Warm pool capped by max idle cost",
+				"available", available, "target", target, "max_hourly_cost", w.maxHourlyCost)
+			break
+		}
+		if err := w.launch(ctx, p); err != nil {
+			logger.Error("Failed to launch warm runner", "error", err)
+			break
+		}
+		*hourlyCost += price
+		idle++
+	}
+	return idle
+}
+
+// isClaimed reports whether the store already assigned a job to the droplet.
+func (w *warmPool) isClaimed(id int) bool {
+	d, ok, err := w.h.store.Droplet(id)
+	return err == nil && ok && d.AssignedJob != 0
+}
+
+func (w *warmPool) deleteIdle(ctx context.Context, inst provider.Instance) bool {
+	if w.isClaimed(inst.ID) || w.h.excluded(inst) {
+		return false
+	}
+	logger := w.h.log.With("runner_name", inst.Name, "droplet_id", inst.ID)
+	if err := w.h.provider.DeleteRunner(ctx, inst.ID); err != nil {
+		metrics.ProviderErrors.WithLabelValues("delete").Inc()
+		logger.Error("Failed to delete idle warm runner", "error", err)
+		return false
+	}
+	if err := w.h.store.MarkDropletDeleted(inst.ID); err != nil {
+		logger.Error("Failed to record droplet deleted", "error", err)
+	}
+	return true
+}
+
+// launch boots one idle runner for pool p.
+func (w *warmPool) launch(ctx context.Context, p *pool.Pool) error {
+	owner, repo, _ := strings.Cut(p.Warm.Repo, "/")
+	runnerName := fmt.Sprintf("%s%s-%d", warmRunnerPrefix, p.Name, time.Now().UnixNano())
+	if len(runnerName) > 63 {
+		runnerName = runnerName[:63]
+	}
+
+	spec := runnerSpec(p)
+	spec.Tags = append(spec.Tags, provider.WarmTag)
+
+	// No delivery names the installation; look it up from the warm repo's owner.
+	installation, err := w.h.githubApp.AccountInstallation(ctx, owner)
+	if err != nil {
+		return err
+	}
+	ctx = gh.WithInstallation(ctx, installation)
+
+	inst, err := w.h.launchRunner(ctx, p, spec, owner, repo, runnerName, p.Labels)
+	if err != nil {
+		return err
+	}
+	logger := w.h.log.With("pool", p.Name, "runner_name", runnerName, "droplet_id", inst.ID)
+	if err := w.h.store.RecordWarmDroplet(p.Name, runnerName, inst.ID); err != nil {
+		logger.Error("Failed to record warm droplet", "error", err)
+	}
+	logger.Info("Launched warm runner")
+	return nil
+}
@@ -0,0 +1,214 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/thomasvincent/github-runners-infra/internal/pool"
+	"github.com/thomasvincent/github-runners-infra/internal/provider"
+	"github.com/thomasvincent/github-runners-infra/internal/state"
+)
+
+func newWarmTestHandler() (*Handler, *pool.Pool) {
+	p := &pool.Pool{
+		Name:   "chef",
+		Labels: []string{"self-hosted", "chef"},
+		Warm:   &pool.Warm{Idle: 2, Repo: "org/cookbooks"},
+	}
+	h := NewHandler(Config{
+		WebhookSecret: []byte(testSecret),
+		Pools:         pool.Set{p},
+	})
+	return h, p
+}
+
+func TestWarmReserve(t *testing.T) {
+	h, p := newWarmTestHandler()
+	h.warm.idle["chef"] = 1
+
+	if h.warm.reserve(p, "org/other-repo", 1) {
+		t.Error("warm runners only serve the repo they are registered with")
+	}
+	if !h.warm.reserve(p, "Org/Cookbooks", 1) {
+		t.Error("expected idle runner to be reserved")
+	}
+	if h.warm.reserve(p, "org/cookbooks", 2) {
+		t.Error("only one idle runner, second job must get its own droplet")
+	}
+
+	noWarm := &pool.Pool{Name: "default", Labels: []string{"self-hosted"}}
+	if h.warm.reserve(noWarm, "org/cookbooks", 3) {
+		t.Error("pool without warm config should never reserve")
+	}
+}
+
+func TestWarmReserveSkipsPausedRepos(t *testing.T) {
+	h, p := newWarmTestHandler()
+	h.warm.idle["chef"] = 1
+	_ = h.store.Pause("org/cookbooks")
+
+	if h.warm.reserve(p, "org/cookbooks", 1) {
+		t.Error("paused repo must not reserve an idle runner")
+	}
+	_, _ = h.store.Resume("org/cookbooks")
+	if !h.warm.reserve(p, "org/cookbooks", 1) {
+		t.Error("expected idle runner reserved after resume")
+	}
+}
+
+func TestWarmReserveOrgPoolServesWholeOrg(t *testing.T) {
+	h, p := newWarmTestHandler()
+	p.RunnerGroup = "cookbook-runners"
+	h.warm.idle["chef"] = 2
+
+	if !h.warm.reserve(p, "org/other-cookbook", 1) {
+		t.Error("org runners should serve any repo of the org")
+	}
+	if h.warm.reserve(p, "someone-else/cookbooks", 2) {
+		t.Error("org runners must not serve repos of another owner")
+	}
+}
+
+func TestWarmReleaseFreesReservation(t *testing.T) {
+	h, p := newWarmTestHandler()
+	h.warm.idle["chef"] = 1
+
+	h.warm.reserve(p, "org/cookbooks", 1)
+	// Job 1 landed on a regular runner, so the idle one is free again.
+	h.warm.release(1)
+
+	if !h.warm.reserve(p, "org/cookbooks", 2) {
+		t.Error("released reservation should free the idle runner")
+	}
+}
+
+func TestWarmReservationExpiryRequeuesJob(t *testing.T) {
+	h, p := newWarmTestHandler()
+	h.warm.idle["chef"] = 1
+
+	_, _ = h.store.Enqueue(1, 0, "org/cookbooks", "chef", p.Labels, nil)
+	h.warm.reserve(p, "org/cookbooks", 1)
+	_ = h.store.Transition(1, state.StatusWarmReserved, "chef")
+
+	h.warm.mu.Lock()
+	expired := h.warm.pruneReservationsLocked("chef", time.Now().Add(warmReservationTTL+time.Second))
+	remaining := len(h.warm.reserved["chef"])
+	h.warm.mu.Unlock()
+	h.warm.requeue("chef", expired)
+
+	if remaining != 0 {
+		t.Errorf("expected expired reservation dropped, %d left", remaining)
+	}
+	if job, _, _ := h.store.Job(1); job.Status != state.StatusQueued {
+		t.Errorf("expected job requeued for its own droplet, got %s", job.Status)
+	}
+}
+
+func TestCompletedJobDropsWarmReservation(t *testing.T) {
+	h, p := newWarmTestHandler()
+	h.warm.idle["chef"] = 1
+
+	queued := WorkflowJobEvent{
+		Action:      "queued",
+		WorkflowJob: WorkflowJob{ID: 1, Labels: p.Labels},
+		Repo:        RepoInfo{FullName: "org/cookbooks"},
+	}
+	postEvent(h, queued, "guid-1")
+	cancelled := queued
+	cancelled.Action = "completed"
+	cancelled.WorkflowJob.Conclusion = "cancelled"
+	postEvent(h, cancelled, "guid-2")
+
+	if n := len(h.warm.reserved["chef"]); n != 0 {
+		t.Errorf("expected reservation of cancelled job dropped, %d left", n)
+	}
+	// Even a stale reservation must not bring the job back.
+	h.warm.requeue("chef", []int64{1})
+	if job, _, _ := h.store.Job(1); job.Status != state.StatusCompleted {
+		t.Errorf("expected cancelled job left completed, got %s", job.Status)
+	}
+}
+
+func TestQueuedJobUsesWarmRunner(t *testing.T) {
+	h, _ := newWarmTestHandler()
+	h.warm.idle["chef"] = 1
+
+	event := WorkflowJobEvent{
+		Action:      "queued",
+		WorkflowJob: WorkflowJob{ID: 1, Labels: []string{"self-hosted", "chef"}},
+		Repo:        RepoInfo{FullName: "org/cookbooks"},
+	}
+	w := postEvent(h, event, "guid-1")
+	if w.Code != 202 {
+		t.Fatalf("expected 202, got %d", w.Code)
+	}
+	if job, _, _ := h.store.Job(1); job.Status != state.StatusWarmReserved {
+		t.Errorf("expected job to wait for warm runner, got %s", job.Status)
+	}
+	// Not claimable by provisioning workers.
+	if _, ok, _ := h.store.ClaimNext(time.Now()); ok {
+		t.Error("warm-reserved job must not be provisioned")
+	}
+}
+
+func TestDuplicateQueuedJobDoesNotHoldWarmRunner(t *testing.T) {
+	h, p := newWarmTestHandler()
+	h.warm.idle["chef"] = 2
+
+	event := WorkflowJobEvent{
+		Action:      "queued",
+		WorkflowJob: WorkflowJob{ID: 1, Labels: p.Labels},
+		Repo:        RepoInfo{FullName: "org/cookbooks"},
+	}
+	postEvent(h, event, "guid-1")
+	if w := postEvent(h, event, "guid-2"); w.Code != 200 {
+		t.Fatalf("expected duplicate answered 200, got %d", w.Code)
+	}
+	if n := len(h.warm.reserved["chef"]); n != 1 {
+		t.Errorf("expected one reservation for the job, got %d", n)
+	}
+}
+
+func TestWarmReconcileRemovesStaleAndExcess(t *testing.T) {
+	h, p := newWarmTestHandler()
+	p.Warm.Idle = 1
+	fake := newFakeProvider()
+	h.provider = fake
+
+	tags := []string{p.Tag(), provider.WarmTag}
+	now := time.Now()
+	fake.instances[1] = provider.Instance{ID: 1, Name: "warm-chef-1", Tags: tags, Created: now.Add(-30 * time.Minute)}
+	fake.instances[2] = provider.Instance{ID: 2, Name: "warm-chef-2", Tags: tags, Created: now.Add(-3 * time.Minute)}
+	fake.instances[3] = provider.Instance{ID: 3, Name: "warm-chef-3", Tags: tags, Created: now.Add(-2 * time.Minute)}
+	fake.instances[4] = provider.Instance{ID: 4, Name: "warm-chef-4", Tags: tags, Created: now.Add(-1 * time.Minute)}
+
+	h.warm.reconcile(context.Background())
+
+	deleted := fake.deletedIDs()
+	if len(deleted) != 3 || deleted[0] != 1 || deleted[1] != 2 || deleted[2] != 3 {
+		t.Errorf("expected stale then oldest runners deleted, got %v", deleted)
+	}
+	if idle := h.warm.idle["chef"]; idle != 1 {
+		t.Errorf("expected 1 idle runner, got %d", idle)
+	}
+}
+
+func TestWarmReconcileRemovesRunnersOfUnknownPools(t *testing.T) {
+	h, p := newWarmTestHandler()
+	p.Warm.Idle = 0
+	fake := newFakeProvider()
+	h.provider = fake
+
+	// A pool that was removed, and one that lost its warm block.
+	h.pools = append(h.pools, &pool.Pool{Name: "cold", Labels: []string{"self-hosted", "cold"}})
+	now := time.Now()
+	fake.instances[1] = provider.Instance{ID: 1, Name: "warm-gone-1", Tags: []string{"pool:gone", provider.WarmTag}, Created: now, PriceHourly: 1}
+	fake.instances[2] = provider.Instance{ID: 2, Name: "warm-cold-2", Tags: []string{"pool:cold", provider.WarmTag}, Created: now, PriceHourly: 1}
+
+	h.warm.reconcile(context.Background())
+
+	if deleted := fake.deletedIDs(); len(deleted) != 2 {
+		t.Errorf("expected both orphaned warm runners deleted, got %v", deleted)
+	}
+}